@@ -16,11 +16,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Natolumin/multidrop/mcastutil"
@@ -31,6 +38,9 @@ import (
 
 var debug bool
 
+// batchSize is the number of packets read per system call
+var batchSize = mcastutil.DefaultBatchSize
+
 func init() {
 	flag.BoolVar(&debug, "v", false, "Be more verbose")
 }
@@ -39,8 +49,23 @@ func main() {
 	group := flag.String("group", "", "Group on which to listen for the stream")
 	port := flag.Int("port", -1, "The port on which to listen for the stream")
 	channel := flag.String("channel", "", "Channel to find in the SAP announcement then listen to. Defaults to all channels")
+	rcvbuf := flag.Int("rcvbuf", 4<<20, "Socket receive buffer size in bytes, 0 for the OS default. "+
+		"It may be capped by the OS, eg. by net.core.rmem_max on linux")
+	batch := flag.Int("batch", mcastutil.DefaultBatchSize, "Number of packets read per system call")
 	flag.Parse()
 
+	// Streams are torn down on interruption, leaving their groups
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	mcastutil.ReceiveBufferSize = *rcvbuf
+	batchSize = *batch
+
 	if *channel != "" && *group != "" {
 		log.Println("Incompatible options: channel and group")
 		flag.PrintDefaults()
@@ -61,14 +86,15 @@ func main() {
 		if err != nil {
 			log.Fatalf("Could not listen on rtp address: %v", err)
 		}
-		parseRTP("["+*group+"]:"+strconv.Itoa(*port), rtpconn, gaddr)
+		identifier := "[" + *group + "]:" + strconv.Itoa(*port)
+		log.Printf("%s: %v", identifier, monitorRTP(ctx, identifier, rtpconn, gaddr))
 	} else {
 		tc, err := mcastutil.ListenMulticastUDP(sap.DefaultSAPGroups, sap.SAPPort, nil)
 		if err != nil {
 			log.Fatalf("Could not connect to all multicast groups: %v", err)
 		}
 		conn := (*sap.SDPConn)(tc)
-		groups := conn.CountStreams()
+		groups := conn.CountStreamsContext(ctx, sap.DefaultSAPGroups, nil)
 
 		var filter sap.ChannelFilter
 		if *channel != "" {
@@ -78,40 +104,195 @@ func main() {
 			filter = sap.FilterNotExpired
 		}
 
-		knownChannels := map[string]*net.UDPConn{}
-		for groups.WaitChange() {
-			for grp := range groups.Iterator(filter) {
-				gaddr = &net.UDPAddr{
-					IP:   net.ParseIP(grp.Session.Connection.Address),
-					Port: grp.Session.Media[0].Port,
-				}
-				if knownChannels[grp.Session.Name] != nil {
-					//TODO: lock + map and cleanup when quitting parseRTP
-					continue
+		monitors := &channelMonitors{ctx: ctx, filter: filter, monitors: make(map[string]*channelMonitor)}
+		sub := groups.Subscribe()
+		var dropped uint64
+	loop:
+		for {
+			select {
+			case ev, ok := <-sub.Events():
+				if !ok {
+					break loop
 				}
-				log.Printf("Found channel %s on group %v ", grp.Session.Name, gaddr)
-				var err error = nil
-				knownChannels[grp.Session.Name], err =
-					mcastutil.ListenMulticastUDP([]net.IP{gaddr.IP}, gaddr.Port, nil)
-				if err != nil {
-					log.Printf("Could not listen on rtp address: %v", err)
-					continue
+				monitors.handle(ev)
+			case _, ok := <-sub.Changes():
+				if !ok {
+					break loop
 				}
-				go parseRTP(grp.Session.Name, knownChannels[grp.Session.Name], gaddr)
 			}
+			// Missed expiries would leave monitors running forever, and missed announcements unmonitored
+			if d := sub.Dropped(); d != dropped {
+				log.Printf("%d SAP events dropped, resynchronizing channels", d-dropped)
+				dropped = d
+				monitors.reconcile(groups.Snapshot(filter))
+			}
+		}
+		monitors.stopAll()
+		log.Printf("SAP listener stopped: %v", groups.Err())
+	}
+}
+
+// channelMonitors are the RTP monitors of the announced channels, by session name
+type channelMonitors struct {
+	ctx    context.Context
+	filter sap.ChannelFilter
+
+	lock     sync.Mutex
+	monitors map[string]*channelMonitor
+	running  sync.WaitGroup
+}
+
+type channelMonitor struct {
+	addr string
+	stop context.CancelFunc
+}
+
+// handle starts and stops monitors following a change of an announced session
+func (c *channelMonitors) handle(ev sap.Event) {
+	switch ev.Type {
+	case sap.SessionModified:
+		if rtpAddr(ev.Before).String() != rtpAddr(ev.After).String() || ev.Before.Session.Name != ev.After.Session.Name {
+			c.stop(ev.Before.Session.Name, ev.Type.String())
+		}
+	case sap.SessionDeleted, sap.SessionExpired, sap.SessionReplaced:
+		c.stop(ev.Before.Session.Name, ev.Type.String())
+		return
+	}
+	c.start(ev.After)
+}
+
+// reconcile stops the monitors of the channels which are not announced anymore, and starts the missing ones
+func (c *channelMonitors) reconcile(sessions sap.Sessions) {
+	announced := make(map[string]string, len(sessions))
+	for _, grp := range sessions {
+		announced[grp.Session.Name] = rtpAddr(grp).String()
+	}
+	c.lock.Lock()
+	var gone []string
+	for name, m := range c.monitors {
+		if addr, ok := announced[name]; !ok || addr != m.addr {
+			gone = append(gone, name)
+		}
+	}
+	c.lock.Unlock()
+	for _, name := range gone {
+		c.stop(name, "not announced anymore")
+	}
+	for _, grp := range sessions {
+		c.start(grp)
+	}
+}
+
+// start starts monitoring a channel, unless it is already monitored
+func (c *channelMonitors) start(grp sap.AdvLifetime) {
+	gaddr := rtpAddr(grp)
+	if !c.filter(&grp) || gaddr == nil {
+		return
+	}
+	name := grp.Session.Name
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.monitors[name] != nil {
+		return
+	}
+	log.Printf("Found channel %s on group %v ", name, gaddr)
+	rtpconn, err := mcastutil.ListenMulticastUDP([]net.IP{gaddr.IP}, gaddr.Port, nil)
+	if err != nil {
+		log.Printf("Could not listen on rtp address: %v", err)
+		return
+	}
+	chanCtx, stop := context.WithCancel(c.ctx)
+	m := &channelMonitor{addr: gaddr.String(), stop: stop}
+	c.monitors[name] = m
+	c.running.Add(1)
+	go func() {
+		defer c.running.Done()
+		log.Printf("%s: %v", name, monitorRTP(chanCtx, name, rtpconn, gaddr))
+		stop()
+		// Stopped streams are monitored again on their next announcement
+		c.lock.Lock()
+		if c.monitors[name] == m {
+			delete(c.monitors, name)
+		}
+		c.lock.Unlock()
+	}()
+}
+
+// stop stops monitoring a channel
+func (c *channelMonitors) stop(name, reason string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if m := c.monitors[name]; m != nil {
+		log.Printf("Channel %s %s, stop listening", name, reason)
+		m.stop()
+		delete(c.monitors, name)
+	}
+}
+
+// stopAll stops all monitors and waits for them to leave their groups
+func (c *channelMonitors) stopAll() {
+	c.lock.Lock()
+	for name, m := range c.monitors {
+		m.stop()
+		delete(c.monitors, name)
+	}
+	c.lock.Unlock()
+	c.running.Wait()
+}
+
+// errNoPacket is returned by monitorRTP when a stream stops
+var errNoPacket = errors.New("Timeout exceeded: No packet received")
+
+// monitorRTP reports packet loss on an RTP stream until ctx is done or the stream stops. The group is then left and
+// the connection closed before the final error is returned
+func monitorRTP(ctx context.Context, identifier string, conn *net.UDPConn, gaddr *net.UDPAddr) error {
+	finished := make(chan struct{})
+	teardown := make(chan struct{})
+	go func() {
+		defer close(teardown)
+		select {
+		case <-ctx.Done():
+		case <-finished:
 		}
+		// Closing the connection interrupts the pending read
+		_ = mcastutil.LeaveGroups(conn, []net.IP{gaddr.IP}, nil)
+		conn.Close()
+	}()
+	err := parseRTP(identifier, conn, gaddr)
+	close(finished)
+	<-teardown
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	return err
 }
 
-func parseRTP(identifier string, conn *net.UDPConn, filterIP *net.UDPAddr) {
-	b := make([]byte, 1500)
+func rtpAddr(grp sap.AdvLifetime) *net.UDPAddr {
+	// Sessions without an SDP description, eg. with another payload type, have no RTP address
+	if grp.Session.Connection == nil || len(grp.Session.Media) == 0 {
+		return nil
+	}
+	return &net.UDPAddr{
+		IP:   net.ParseIP(grp.Session.Connection.Address),
+		Port: grp.Session.Media[0].Port,
+	}
+}
+
+func parseRTP(identifier string, conn *net.UDPConn, filterIP *net.UDPAddr) error {
+	reader := mcastutil.NewBatchReader(conn, batchSize, 0)
 	var seqnum uint16
 	var started bool
 	for {
 		_ = conn.SetReadDeadline(time.Now().Add(time.Minute * 2))
-		len, daddr, err := conn.ReadFromUDP(b)
+		b, oob, _, _, err := reader.ReadMsgUDP()
+
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return errNoPacket
+		} else if err != nil {
+			return fmt.Errorf("Could not read from connection: %v", err)
+		}
 
-		if daddr != filterIP {
+		if daddr, _, _ := mcastutil.ParseControlMessages(oob); daddr != nil && !daddr.Equal(filterIP.IP) {
 			// On linux, with IPv4 without IP_MULTICAST_ALL or with IPv6, *all* the multicast streams that
 			// *any socket on the machine* is subscribed to are distributed in *all* the sockets that match.
 			// That means that if any other process on the machine is subscribed to an rtp stream on the
@@ -119,20 +300,12 @@ func parseRTP(identifier string, conn *net.UDPConn, filterIP *net.UDPAddr) {
 			// So yes, we have to do daddr filtering in userspace. Yes it is stupid.
 			continue
 		}
-
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			log.Printf("%s: Timeout exceeded: No packet received", identifier)
-			return
-		} else if err != nil {
-			log.Printf("%s: Could not read from connection: %v", identifier, err)
-			return
-		}
-		decoded, err := rtp.ParsePacket(b[:len])
+		decoded, err := rtp.ParsePacket(b)
 		if err != nil {
 			if debug {
 				log.Printf("%s: Malformed packet (err: %v) %v", identifier, err, b)
 			}
-			return
+			return fmt.Errorf("Malformed packet: %v", err)
 		}
 		if !started {
 			log.Printf("%s: Stream start at sequence %d", identifier, decoded.SequenceNumber)
@@ -0,0 +1,82 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Natolumin/multidrop/sap"
+)
+
+// runLint reports the problems found in the description of announced sessions, when they appear or change
+func runLint(conn *sap.SDPConn) {
+	streams := conn.CountStreams()
+	defer streams.Close()
+	sub := streams.Subscribe()
+	defer sub.Close()
+
+	// reported are the problems last printed for each session. The problems of a session also change with the other
+	// sessions, without event for it: they are found in snapshots, which no coalesced notification can miss
+	reported := make(map[sap.SessionKey][]sap.Lint)
+	for sub.Wait() {
+		sessions := streams.Snapshot(sap.FilterNotExpired)
+		sessions.Sort(func(a, b *sap.AdvLifetime) bool { return a.Name < b.Name })
+		live := make(map[sap.SessionKey]bool, len(sessions))
+		for i := range sessions {
+			live[sessions[i].Key] = true
+			reportLints(reported, &sessions[i])
+		}
+		for key := range reported {
+			if !live[key] {
+				delete(reported, key)
+			}
+		}
+	}
+	if err := streams.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// reportLints prints the problems of a session if they changed since they were last reported
+func reportLints(reported map[sap.SessionKey][]sap.Lint, lf *sap.AdvLifetime) {
+	previous := reported[lf.Key]
+	reported[lf.Key] = lf.Lints
+	if sameLints(previous, lf.Lints) {
+		return
+	}
+	name := lf.Name
+	if lf.Origin != nil {
+		name = fmt.Sprintf("%s (%s)", name, lf.Origin.Address)
+	}
+	if len(lf.Lints) == 0 {
+		fmt.Printf("%s: ok\n", name)
+	}
+	for _, lint := range lf.Lints {
+		fmt.Printf("%s: %s\n", name, lint)
+	}
+}
+
+func sameLints(a, b []sap.Lint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
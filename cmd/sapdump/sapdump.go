@@ -45,26 +45,36 @@ func main() {
 		}
 	}
 	format = flag.String("format", defFormat, "Format string following text/template for dumping SAP announcements")
+	lint := flag.Bool("lint", false, "Report problems in the descriptions of announced sessions instead of dumping announcements")
 
 	//common options
 	group := flag.String("group", "", "Comma-separated Group(s) on which to listen for SAP announcements.")
 	v6only := flag.Bool("6", false, "Only listen on ipv6 groups (overriden by -group)")
 	v4only := flag.Bool("4", false, "Only listen on ipv4 groups (overriden by -group)")
 
-	ifname := flag.String("i", "", "Force binding to a specific interface for multicast group. "+
+	ifname := flag.String("i", "", "Comma-separated interface(s) on which to join the multicast groups. "+
 		"Without this, the OS default is used, which may often not be what you want")
+	reassembly := flag.Bool("reassembly", false, "Accept announcements larger than the MTU, up to 64KiB")
 
 	flag.Parse()
 
+	if *reassembly {
+		sap.ReadBufferSize = sap.MaxDatagramSize
+	}
+
 	if *v6only && *v4only {
 		log.Fatal("Incompatible flags -4 and -6")
 	}
 
-	var iface *net.Interface
+	ifaces := []*net.Interface{nil}
 	if *ifname != "" {
-		var err error
-		if iface, err = net.InterfaceByName(*ifname); err != nil {
-			log.Fatalf("Could not find interface %s: %v\n", *ifname, err)
+		names := strings.Split(*ifname, ",")
+		ifaces = make([]*net.Interface, len(names))
+		for i, name := range names {
+			var err error
+			if ifaces[i], err = net.InterfaceByName(name); err != nil {
+				log.Fatalf("Could not find interface %s: %v\n", name, err)
+			}
 		}
 	}
 
@@ -73,10 +83,13 @@ func main() {
 	if *group == "" {
 		if *v4only {
 			gaddrs = []net.IP{sap.GroupAddr4}
+			for _, s := range sap.IPv4AdminScopes {
+				gaddrs = append(gaddrs, s.Group)
+			}
 		} else if *v6only {
 			gaddrs = []net.IP{sap.V6GroupByZone(2), sap.V6GroupByZone(5), sap.V6GroupByZone(8), sap.V6GroupByZone(0xe)}
 		} else {
-			gaddrs = []net.IP{sap.V6GroupByZone(2), sap.V6GroupByZone(5), sap.V6GroupByZone(8), sap.V6GroupByZone(0xe), sap.GroupAddr4}
+			gaddrs = sap.DefaultSAPGroups
 		}
 	} else {
 		groups := strings.Split(*group, ",")
@@ -85,12 +98,17 @@ func main() {
 			gaddrs[i] = net.ParseIP(g)
 		}
 	}
-	tc, err := mcastutil.ListenMulticastUDP(gaddrs, sap.SAPPort, iface)
+	tc, err := mcastutil.ListenMulticastUDPOn(gaddrs, sap.SAPPort, ifaces)
 	if err != nil {
 		log.Fatalf("Could not join all multicast groups: %v", err)
 	}
 	conn := (*sap.SDPConn)(tc)
 
+	if *lint {
+		runLint(conn)
+		return
+	}
+
 	// now loop-dump everything
 	if !curses {
 		tmpl, err := template.New("format").Parse(*format)
@@ -98,12 +116,17 @@ func main() {
 			log.Fatalf("Invalid template: %s", err)
 		}
 		for {
-			packet, err := conn.Read()
+			// Payloads are decoded by type so that non-SDP announcements are dumped too
+			raw, err := (*sap.Conn)(conn).Read()
+			var packet *sap.DecodedPacket
+			if err == nil {
+				packet, err = raw.Decode()
+			}
 			if err == nil {
-				if err = tmpl.Execute(os.Stdout, packet); err != nil {
-					log.Fatal(err)
-				}
-			} else {
+				// SDP fields are missing from other payloads, which are skipped like undecodable packets
+				err = tmpl.Execute(os.Stdout, packet)
+			}
+			if err != nil {
 				log.Print(err)
 			}
 		}
@@ -12,24 +12,32 @@ import (
 	"github.com/Natolumin/multidrop/sap"
 
 	"github.com/LINBIT/termui"
-	"github.com/emirpasic/gods/sets/treeset"
-	godsutils "github.com/emirpasic/gods/utils"
 	"github.com/pixelbender/go-sdp/sdp"
 )
 
 const timeResolution = time.Second
 
-var filter = func(lf *sap.AdvLifetime) bool {
-	return lf.Last.Add(lf.Interval*10).After(time.Now()) || (lf.Count == 1 && lf.Last.Add(time.Minute*2).After(time.Now()))
+// expiry hides quickly sessions which are not announced anymore, and sessions announced only once
+var expiry = sap.ExpiryPolicy{
+	IntervalFactor: 10,
+	SingleTimeout:  time.Minute * 2,
+	Tombstone:      time.Minute * 2,
 }
 
+var filter = expiry.Filter()
+
 func init() {
 	runTermui = runTermuiImpl
 }
 
 func runTermuiImpl(conn *sap.SDPConn) {
 	streams := conn.CountStreams()
+	if err := streams.SetExpiryPolicy(expiry); err != nil {
+		log.Panic(err)
+	}
 	defer streams.Close()
+	collisions := sap.NewCollisionAnalyzer(streams, filter)
+	defer collisions.Close()
 
 	err := termui.Init()
 	if err != nil {
@@ -37,10 +45,12 @@ func runTermuiImpl(conn *sap.SDPConn) {
 	}
 	defer termui.Close()
 
+	changes := streams.Subscribe()
+	defer changes.Close()
 	go func() {
 		evchan := termui.NewSysEvtCh()
 		termui.Merge("user events", evchan)
-		for streams.WaitChange() {
+		for changes.Wait() {
 			evchan <- termui.Event{
 				Path: "/net/recv",
 				Time: time.Now().Unix(),
@@ -56,10 +66,10 @@ func runTermuiImpl(conn *sap.SDPConn) {
 	tbl.Height = termui.TermHeight()
 
 	termui.Handle("/net/recv", func(termui.Event) {
-		updateDisplay(tbl, streams)
+		updateDisplay(tbl, streams, collisions)
 	})
 	termui.Handle("/timers/1s", func(termui.Event) {
-		updateDisplay(tbl, streams)
+		updateDisplay(tbl, streams, collisions)
 	})
 	termui.Handle("/sys/kbd/q", func(termui.Event) {
 		termui.StopLoop()
@@ -74,34 +84,61 @@ func runTermuiImpl(conn *sap.SDPConn) {
 	termui.Loop()
 }
 
-func updateDisplay(tbl *termui.Table, streams sap.StreamsAccumulator) {
-	treeset := treeset.NewWith(func(a, b interface{}) int {
-		return godsutils.StringComparator(
-			a.(sap.AdvLifetime).Name+strconv.Itoa(int(a.(sap.AdvLifetime).Hash)),
-			b.(sap.AdvLifetime).Name+strconv.Itoa(int(b.(sap.AdvLifetime).Hash)),
-		)
-	})
-	displayed := [][]string{[]string{"Session", "Last Adv.", "Nb.", "Interval", "Group Address"}}
+func updateDisplay(tbl *termui.Table, streams sap.StreamsAccumulator, collisions *sap.CollisionAnalyzer) {
+	displayed := [][]string{[]string{"Session", "SAP", "Last Adv.", "Nb.", "Interval", "Group Address", "Scope",
+		"Missing On", "Warnings"}}
 
-	for channel := range streams.Iterator(filter) {
-		treeset.Add(channel)
-	}
-	it := treeset.Iterator()
-	for it.Next() {
-		channel := it.Value().(sap.AdvLifetime)
+	channels := streams.Snapshot(filter)
+	channels.Sort(sap.ByName)
+	for _, channel := range channels {
+		name := channel.Name
+		if channel.Encrypted {
+			name = "(encrypted, no key)"
+		} else if channel.PayloadType != sap.SDPPayloadType {
+			name = "(" + channel.PayloadType + ")"
+		}
+		scope := channel.Scope
+		if channel.MisScoped {
+			scope += " (expected " + channel.ConnectionScope + ")"
+		}
 		displayed = append(displayed, []string{
-			channel.Name,
+			name,
+			"v" + strconv.Itoa(int(channel.SAPVersion)),
 			channel.Last.Format("15:04:05.000"),
 			strconv.Itoa(channel.Count),
 			(channel.Interval / timeResolution * timeResolution).String(),
 			groupAddr(channel.Session),
+			scope,
+			strings.Join(channel.MissingOn, ","),
+			warnings(channel.Key, channels, collisions),
 		})
 	}
 	tbl.SetRows(displayed)
 	termui.Render(tbl)
 }
 
+// warnings describes the collisions of a session with the other displayed sessions
+func warnings(key sap.SessionKey, channels sap.Sessions, collisions *sap.CollisionAnalyzer) string {
+	var warns []string
+	for _, c := range collisions.Of(key) {
+		var others []string
+		for _, k := range c.Sessions {
+			if other, ok := channels.Find(k); ok && k != key {
+				others = append(others, other.Name)
+			}
+		}
+		if len(others) > 0 {
+			warns = append(warns, "collides on "+net.JoinHostPort(c.Group.String(), strconv.Itoa(c.Port))+
+				" with "+strings.Join(others, ","))
+		}
+	}
+	return strings.Join(warns, "; ")
+}
+
 func groupAddr(d sdp.Session) string {
+	if d.Origin == nil {
+		return ""
+	}
 	addr := d.Origin.Address
 	if net.ParseIP(addr).To4() == nil {
 		addr = "[" + addr + "]"
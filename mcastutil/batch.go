@@ -0,0 +1,99 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mcastutil
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// DefaultBatchSize is the default number of datagrams read per system call by a BatchReader
+const DefaultBatchSize = 32
+
+// batchConn is implemented by both ipv4.PacketConn and ipv6.PacketConn, whose Message types are the same
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+//BatchReader reads datagrams from a socket several at a time, with recvmmsg where available
+type BatchReader struct {
+	conn    batchConn
+	msgs    []ipv4.Message
+	bufSize int
+	n, next int
+}
+
+//NewBatchReader creates a reader of up to batchSize datagrams of bufSize bytes per system call. When bufSize is
+//zero, the largest MTU of the interfaces is used. The control messages enabled by EnableControlMessages are received
+//along with the datagrams
+func NewBatchReader(conn *net.UDPConn, batchSize, bufSize int) *BatchReader {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	if bufSize <= 0 {
+		bufSize = maxMTU
+	}
+	r := &BatchReader{msgs: make([]ipv4.Message, batchSize), bufSize: bufSize}
+	// The local address of IPv4 sockets is 4 bytes long, even when bound to the wildcard address
+	if laddr, ok := conn.LocalAddr().(*net.UDPAddr); ok && len(laddr.IP) == net.IPv4len {
+		r.conn = ipv4.NewPacketConn(conn)
+	} else {
+		r.conn = ipv6.NewPacketConn(conn)
+	}
+	for i := range r.msgs {
+		r.msgs[i].Buffers = [][]byte{make([]byte, bufSize)}
+		r.msgs[i].OOB = make([]byte, OOBSize)
+	}
+	return r
+}
+
+//BufferSize returns the size of the buffer of each datagram, larger datagrams are truncated
+func (r *BatchReader) BufferSize() int {
+	return r.bufSize
+}
+
+//ReadMsgUDP returns the next datagram like net.UDPConn.ReadMsgUDP, reading a new batch when the previous one is
+//exhausted. The returned buffers are only valid until the next call
+func (r *BatchReader) ReadMsgUDP() (b, oob []byte, flags int, src *net.UDPAddr, err error) {
+	if r.next >= r.n {
+		r.next = 0
+		if r.n, err = r.conn.ReadBatch(r.msgs, 0); err != nil {
+			r.n = 0
+			return
+		}
+	}
+	msg := &r.msgs[r.next]
+	r.next++
+	src, _ = msg.Addr.(*net.UDPAddr)
+	return msg.Buffers[0][:msg.N], msg.OOB[:msg.NN], msg.Flags, src, nil
+}
+
+//ParseControlMessages returns the destination address, interface index and TTL/hop limit of a received datagram from
+//its control messages. dst is nil and hopLimit is -1 when unknown
+func ParseControlMessages(oob []byte) (dst net.IP, ifIndex, hopLimit int) {
+	hopLimit = -1
+	// Each parser ignores the control messages of the other protocol
+	var cm6 ipv6.ControlMessage
+	if cm6.Parse(oob) == nil && cm6.Dst != nil {
+		dst, ifIndex, hopLimit = cm6.Dst, cm6.IfIndex, cm6.HopLimit
+	}
+	var cm4 ipv4.ControlMessage
+	if cm4.Parse(oob) == nil && cm4.Dst != nil {
+		dst, ifIndex, hopLimit = cm4.Dst, cm4.IfIndex, cm4.TTL
+	}
+	return
+}
@@ -16,7 +16,9 @@
 package mcastutil
 
 import (
+	"context"
 	"net"
+	"time"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
@@ -24,6 +26,10 @@ import (
 
 var maxMTU = 1500
 
+//ReceiveBufferSize is the socket receive buffer size set by ListenMulticastUDP, or 0 for the OS default. Large
+//buffers avoid drops when the reader is late, the OS may cap them (see net.core.rmem_max on linux)
+var ReceiveBufferSize = 0
+
 func init() {
 	ifaces, err := net.Interfaces()
 	if err != nil {
@@ -59,16 +65,113 @@ func leaveGroup(conn *net.UDPConn, ip *net.IPAddr, ifi *net.Interface) (err erro
 
 //ListenMulticastUDP reimplements net.ListenMulticastUDP with multiple groups simultaneously
 func ListenMulticastUDP(gaddrs []net.IP, port int, ifi *net.Interface) (conn *net.UDPConn, err error) {
+	return ListenMulticastUDPOn(gaddrs, port, []*net.Interface{ifi})
+}
+
+//ListenMulticastUDPOn is like ListenMulticastUDP, but joins the groups on each of the given interfaces. The interface
+//each packet arrives on is available in the control messages, see ParseControlMessages. The connection is closed if
+//its receive buffer cannot be sized or any group cannot be joined
+func ListenMulticastUDPOn(gaddrs []net.IP, port int, ifis []*net.Interface) (conn *net.UDPConn, err error) {
 	// see net/sock_posix.go:184 we need to use a multicast address as laddr for proper SO_REUSEADDR setting
 	conn, err = net.ListenUDP("udp6", &net.UDPAddr{IP: gaddrs[0], Port: port})
 	if err != nil {
 		return
 	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+			conn = nil
+		}
+	}()
+	if ReceiveBufferSize > 0 {
+		if err = conn.SetReadBuffer(ReceiveBufferSize); err != nil {
+			return
+		}
+	}
+
+	for _, ifi := range ifis {
+		for _, gaddr := range gaddrs {
+			if err = joinGroup(conn, &net.IPAddr{IP: gaddr}, ifi); err != nil {
+				return
+			}
+		}
+	}
+	EnableControlMessages(conn)
+	return
+}
 
+//LeaveGroups leaves multicast groups joined with ListenMulticastUDP. All groups are left even if some fail, and the
+//first error is returned
+func LeaveGroups(conn *net.UDPConn, gaddrs []net.IP, ifi *net.Interface) (err error) {
 	for _, gaddr := range gaddrs {
-		if err = joinGroup(conn, &net.IPAddr{IP: gaddr}, ifi); err != nil {
+		if lerr := leaveGroup(conn, &net.IPAddr{IP: gaddr}, ifi); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	return
+}
+
+//InterruptOnDone interrupts the pending and future reads on conn once ctx is done, by setting a past read deadline.
+//The returned function stops watching ctx, and must be called before conn is reused with another context
+func InterruptOnDone(ctx context.Context, conn *net.UDPConn) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetReadDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+const (
+	ipv4Flags = ipv4.FlagDst | ipv4.FlagInterface | ipv4.FlagTTL
+	ipv6Flags = ipv6.FlagDst | ipv6.FlagInterface | ipv6.FlagHopLimit
+)
+
+// OOBSize is the size of the buffer needed to receive the control messages enabled by EnableControlMessages
+var OOBSize = len(ipv4.NewControlMessage(ipv4Flags)) + len(ipv6.NewControlMessage(ipv6Flags))
+
+//EnableControlMessages requests the destination address, interface and TTL/hop limit of received packets as control
+//messages. Errors are ignored as only one of IPv4 or IPv6 may be supported by the socket
+func EnableControlMessages(conn *net.UDPConn) {
+	_ = ipv6.NewPacketConn(conn).SetControlMessage(ipv6Flags, true)
+	_ = ipv4.NewPacketConn(conn).SetControlMessage(ipv4Flags, true)
+}
+
+//SetMulticastHops sets the TTL (IPv4) and hop limit (IPv6) of outgoing multicast packets on conn
+func SetMulticastHops(conn *net.UDPConn, hops int) (err error) {
+	pc6 := ipv6.NewPacketConn(conn)
+	if err = pc6.SetMulticastHopLimit(hops); err != nil {
+		return
+	}
+	// v4-mapped destinations use the IPv4 socket options, even on an IPv6 socket
+	pc4 := ipv4.NewPacketConn(conn)
+	_ = pc4.SetMulticastTTL(hops)
+	return
+}
+
+//DialMulticastUDP opens a socket to send multicast packets on the given interface, to both IPv6 and v4-mapped groups
+func DialMulticastUDP(ifi *net.Interface) (conn *net.UDPConn, err error) {
+	// "udp" with a wildcard address gives a dual-stack socket, "udp6" would be IPV6_V6ONLY
+	conn, err = net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return
+	}
+	if ifi != nil {
+		pc6 := ipv6.NewPacketConn(conn)
+		if err = pc6.SetMulticastInterface(ifi); err != nil {
+			conn.Close()
 			return
 		}
+		pc4 := ipv4.NewPacketConn(conn)
+		_ = pc4.SetMulticastInterface(ifi)
 	}
 	return
 }
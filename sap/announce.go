@@ -0,0 +1,389 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Natolumin/multidrop/mcastutil"
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+const (
+	// DefaultAnnounceInterval is the default time between two announcements of the same session
+	DefaultAnnounceInterval = 5 * time.Minute
+	// DefaultAnnounceTTL is the TTL/hop limit used for sessions which do not specify one
+	DefaultAnnounceTTL = 255
+)
+
+// MessageIDHash computes the RFC2974 message identifier hash of a payload. Zero is never returned as it is reserved to
+// announcers which do not compute the hash
+func MessageIDHash(payload []byte) uint16 {
+	h := fnv.New32a()
+	h.Write(payload)
+	sum := h.Sum32()
+	if hash := uint16(sum>>16) ^ uint16(sum); hash != 0 {
+		return hash
+	}
+	return 1
+}
+
+// SessionGroup returns the SAP group on which a session should be announced, depending on the scope of its
+// connection address. Addresses of administrative scopes missing from IPv4AdminScopes are rejected
+func SessionGroup(s *sdp.Session) (net.IP, error) {
+	ip, err := connectionAddress(s)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := ScopeOf(ip)
+	if err != nil {
+		return nil, err
+	}
+	if scope.Group == nil {
+		// Announcing it on another scope would leak it past the scope boundaries
+		return nil, fmt.Errorf("no SAP group for %v in the unknown %s scope, add its range to IPv4AdminScopes", ip,
+			scope.Name)
+	}
+	return scope.Group, nil
+}
+
+func sessionKey(s *sdp.Session) (string, error) {
+	if s.Origin == nil {
+		return "", errors.New("session has no origin")
+	}
+	o := s.Origin
+	return fmt.Sprintf("%s %d %s %s %s", o.Username, o.SessionID, o.Network, o.Type, o.Address), nil
+}
+
+func originLine(s *sdp.Session) []byte {
+	o := s.Origin
+	return []byte(fmt.Sprintf("v=0\r\no=%s %d %d %s %s %s\r\n",
+		o.Username, o.SessionID, o.SessionVersion, o.Network, o.Type, o.Address))
+}
+
+// AnnouncerConfig configures an Announcer
+type AnnouncerConfig struct {
+	// OrigSrc is the originating source written in announcements. When nil, the local address used to reach the
+	// SAP group is used
+	OrigSrc net.IP
+	// Interval is the time between two announcements of the same session, when Scheduler is nil. Non-positive
+	// intervals are replaced by DefaultAnnounceInterval
+	Interval time.Duration
+	// Scheduler computes the time of the next announcement of each session according to the scope load
+	Scheduler *Scheduler
+	// Compress enables zlib compression of the announced payloads
+	Compress bool
+	// Signer, when set, is used to authenticate the announcements
+	Signer Signer
+	// LegacyV0 sends SAPv0 announcements, without payload type, for receivers which do not support SAPv1. SAPv0 only
+	// supports IPv4 originating sources
+	LegacyV0 bool
+}
+
+// Announcer periodically announces a set of sessions on their SAP groups
+type Announcer struct {
+	config AnnouncerConfig
+
+	mu       sync.Mutex
+	conn     *net.UDPConn
+	ifi      *net.Interface
+	sessions map[string]*announcement
+	wake     chan struct{}
+	done     chan struct{}
+}
+
+type announcement struct {
+	session sdp.Session
+	packet  Packet
+	group   net.IP
+	ttl     int
+	next    time.Time
+}
+
+// NewAnnouncer creates an Announcer sending on the given interface. If ifi is nil, the OS default is used. The
+// configuration cannot be changed once the Announcer is created
+func NewAnnouncer(ifi *net.Interface, config AnnouncerConfig) (*Announcer, error) {
+	conn, err := mcastutil.DialMulticastUDP(ifi)
+	if err != nil {
+		return nil, err
+	}
+	a := &Announcer{
+		config:   config,
+		conn:     conn,
+		ifi:      ifi,
+		sessions: make(map[string]*announcement),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+// Announce adds sessions to the announced set, or replaces them if a session with the same origin is already
+// announced. The first announcement is sent immediately
+func (a *Announcer) Announce(sessions ...sdp.Session) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counted := make(map[string]int)
+	for i := range sessions {
+		s := sessions[i]
+		key, err := sessionKey(&s)
+		if err != nil {
+			return err
+		}
+		adv, err := a.newAnnouncement(s)
+		if err != nil {
+			return err
+		}
+		if old, ok := a.sessions[key]; ok {
+			if old.packet.IDHash == adv.packet.IDHash {
+				continue
+			}
+			// Receivers of the previous scope would otherwise keep the session until it times out
+			if !old.group.Equal(adv.group) {
+				if err = a.sendDeletion(old); err != nil {
+					return err
+				}
+			}
+		}
+		a.sessions[key] = adv
+		if err = a.send(&adv.packet, adv); err != nil {
+			return err
+		}
+		adv.next = a.nextAnnounce(time.Now(), adv, counted)
+	}
+	a.poke()
+	return nil
+}
+
+// Withdraw removes sessions from the announced set and sends a deletion packet for them
+func (a *Announcer) Withdraw(sessions ...sdp.Session) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range sessions {
+		key, err := sessionKey(&sessions[i])
+		if err != nil {
+			return err
+		}
+		adv, ok := a.sessions[key]
+		if !ok {
+			continue
+		}
+		delete(a.sessions, key)
+		if err = a.sendDeletion(adv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close sends deletion packets for all announced sessions and releases the socket
+func (a *Announcer) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-a.done:
+		return errors.New("announcer already closed")
+	default:
+	}
+	close(a.done)
+
+	var err error
+	for key, adv := range a.sessions {
+		if derr := a.sendDeletion(adv); derr != nil && err == nil {
+			err = derr
+		}
+		delete(a.sessions, key)
+	}
+	if cerr := a.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (a *Announcer) newAnnouncement(s sdp.Session) (*announcement, error) {
+	group, err := SessionGroup(&s)
+	if err != nil {
+		return nil, err
+	}
+	payload := []byte(s.String())
+	adv := &announcement{
+		session: s,
+		group:   group,
+		ttl:     DefaultAnnounceTTL,
+		packet: Packet{
+			Header: Header{
+				Version:     1,
+				Type:        TypeAnnounce,
+				IDHash:      MessageIDHash(payload),
+				OrigSrc:     a.config.OrigSrc,
+				PayloadType: SDPPayloadType,
+			},
+			Payload: payload,
+		},
+	}
+	if a.config.Compress {
+		adv.packet.Compress()
+	}
+	if s.Connection != nil && s.Connection.TTL > 0 {
+		adv.ttl = s.Connection.TTL
+	}
+	if adv.packet.OrigSrc == nil {
+		if adv.packet.OrigSrc, err = a.localAddr(group); err != nil {
+			return nil, err
+		}
+	}
+	if a.config.LegacyV0 {
+		if adv.packet.OrigSrc.To4() == nil {
+			return nil, fmt.Errorf("SAPv0 cannot announce from IPv6 source %v", adv.packet.OrigSrc)
+		}
+		adv.packet.Version = 0
+	}
+	if a.config.Signer != nil {
+		if err = adv.packet.Sign(a.config.Signer); err != nil {
+			return nil, err
+		}
+	}
+	return adv, nil
+}
+
+// localAddr finds the address the announcer uses to reach a group
+func (a *Announcer) localAddr(group net.IP) (net.IP, error) {
+	if a.ifi != nil {
+		addrs, err := a.ifi.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && (ipnet.IP.To4() == nil) == (group.To4() == nil) {
+				return ipnet.IP, nil
+			}
+		}
+	}
+	// Connecting an UDP socket does not send anything but selects the source address
+	c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: group, Port: SAPPort})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func (a *Announcer) sendDeletion(adv *announcement) error {
+	p, err := a.deletionPacket(adv)
+	if err != nil {
+		return err
+	}
+	return a.send(&p, adv)
+}
+
+// deletionPacket builds the deletion packet of an announcement, identified by the origin line of the session
+func (a *Announcer) deletionPacket(adv *announcement) (Packet, error) {
+	p := adv.packet
+	p.Type = TypeDelete
+	p.Payload = originLine(&adv.session)
+	if p.Compressed {
+		p.Payload = deflate(p.Payload)
+	}
+	if a.config.Signer != nil {
+		if err := p.Sign(a.config.Signer); err != nil {
+			return Packet{}, err
+		}
+	}
+	return p, nil
+}
+
+func (a *Announcer) send(p *Packet, adv *announcement) error {
+	b := make([]byte, p.Length())
+	n, err := p.WriteBinary(b)
+	if err != nil {
+		return err
+	}
+	if err = mcastutil.SetMulticastHops(a.conn, adv.ttl); err != nil {
+		return err
+	}
+	_, err = a.conn.WriteToUDP(b[:n], &net.UDPAddr{IP: adv.group, Port: SAPPort})
+	return err
+}
+
+// nextAnnounce computes the time of the next announcement of a session. counted caches the number of announcements
+// of each scope group during a scheduling pass
+func (a *Announcer) nextAnnounce(last time.Time, adv *announcement, counted map[string]int) time.Time {
+	if a.config.Scheduler == nil {
+		return last.Add(a.interval())
+	}
+	group := adv.group.String()
+	ads, ok := counted[group]
+	if !ok {
+		ads = a.config.Scheduler.Ads(adv.group)
+		counted[group] = ads
+	}
+	return a.config.Scheduler.next(ads, last, adv.packet.Length())
+}
+
+func (a *Announcer) interval() time.Duration {
+	if a.config.Interval <= 0 {
+		return DefaultAnnounceInterval
+	}
+	return a.config.Interval
+}
+
+func (a *Announcer) poke() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (a *Announcer) run() {
+	timer := time.NewTimer(a.interval())
+	defer timer.Stop()
+	for {
+		a.mu.Lock()
+		now := time.Now()
+		next := now.Add(a.interval())
+		counted := make(map[string]int)
+		for _, adv := range a.sessions {
+			if !adv.next.After(now) {
+				// Errors are not fatal, the announcement is retried at the next interval
+				_ = a.send(&adv.packet, adv)
+				adv.next = a.nextAnnounce(now, adv, counted)
+			}
+			if adv.next.Before(next) {
+				next = adv.next
+			}
+		}
+		a.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(next.Sub(now))
+		select {
+		case <-timer.C:
+		case <-a.wake:
+		case <-a.done:
+			return
+		}
+	}
+}
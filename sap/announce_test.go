@@ -0,0 +1,124 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+func TestSessionGroup(t *testing.T) {
+	tests := []struct {
+		address string
+		group   net.IP
+	}{
+		{"233.252.0.1", GroupAddr4},
+		{"239.1.2.3", nil},
+		{"239.255.1.2", net.ParseIP("239.255.255.255")},
+		{"239.193.0.1", net.ParseIP("239.195.255.255")},
+		{"ff15::1234", V6GroupByZone(5)},
+		{"ff0e::1", V6GroupByZone(0xe)},
+		{"192.0.2.1", nil},
+		{"2001:db8::1", nil},
+	}
+	for i, test := range tests {
+		s := sdp.Session{Connection: &sdp.Connection{Address: test.address}}
+		group, err := SessionGroup(&s)
+		if test.group == nil {
+			if err == nil {
+				t.Errorf("%d: Address %s without SAP group accepted", i+1, test.address)
+			}
+			continue
+		}
+		if err != nil || !group.Equal(test.group) {
+			t.Errorf("%d: Expected group %v for %s, got %v (%v)", i+1, test.group, test.address, group, err)
+		}
+	}
+}
+
+func TestDeletionPacket(t *testing.T) {
+	s := sdp.Session{
+		Origin:     &sdp.Origin{Username: "-", SessionID: 42, SessionVersion: 1, Network: "IN", Type: "IP4", Address: "192.0.2.1"},
+		Name:       "test",
+		Connection: &sdp.Connection{Network: "IN", Type: "IP4", Address: "233.252.0.1", TTL: 16},
+	}
+	a := Announcer{config: AnnouncerConfig{OrigSrc: net.IPv4(192, 0, 2, 1)}}
+	adv, err := a.newAnnouncement(s)
+	if err != nil {
+		t.Fatalf("Could not create announcement: %v", err)
+	}
+	if adv.ttl != 16 {
+		t.Errorf("Session TTL not used: %d", adv.ttl)
+	}
+	p, err := a.deletionPacket(adv)
+	if err != nil {
+		t.Fatalf("Could not create deletion packet: %v", err)
+	}
+	b := make([]byte, p.Length())
+	n, err := p.WriteBinary(b)
+	if err != nil {
+		t.Fatalf("Could not write deletion packet: %v", err)
+	}
+	header, err := ParseHeader(b[:n])
+	if err != nil {
+		t.Fatalf("Could not parse deletion packet: %v", err)
+	}
+	if header.Type != TypeDelete || header.IDHash != adv.packet.IDHash || !header.OrigSrc.Equal(a.config.OrigSrc) {
+		t.Errorf("Deletion packet does not match announcement: %+v", header)
+	}
+	if payload := b[header.len:n]; string(payload) != string(originLine(&s)) {
+		t.Errorf("Deletion payload is not the origin line: %q", payload)
+	}
+}
+
+func TestInvalidInterval(t *testing.T) {
+	now := time.Now()
+	for i, interval := range []time.Duration{0, -time.Second} {
+		a := Announcer{config: AnnouncerConfig{Interval: interval}}
+		if next := a.nextAnnounce(now, &announcement{}, nil); !next.Equal(now.Add(DefaultAnnounceInterval)) {
+			t.Errorf("%d: Invalid interval %v not replaced by the default: next in %v", i+1, interval, next.Sub(now))
+		}
+	}
+}
+
+func TestLegacyV0(t *testing.T) {
+	s := sdp.Session{
+		Origin:     &sdp.Origin{Username: "-", SessionID: 42, SessionVersion: 1, Network: "IN", Type: "IP4", Address: "192.0.2.1"},
+		Name:       "test",
+		Connection: &sdp.Connection{Network: "IN", Type: "IP4", Address: "233.252.0.1", TTL: 16},
+	}
+	a := Announcer{config: AnnouncerConfig{OrigSrc: net.IPv4(192, 0, 2, 1), LegacyV0: true}}
+	adv, err := a.newAnnouncement(s)
+	if err != nil {
+		t.Fatalf("Could not create SAPv0 announcement: %v", err)
+	}
+	b := make([]byte, adv.packet.Length())
+	n, err := adv.packet.WriteBinary(b)
+	if err != nil {
+		t.Fatalf("Could not write SAPv0 announcement: %v", err)
+	}
+	header, err := ParseHeader(b[:n])
+	if err != nil || header.Version != 0 || string(b[header.len:header.len+3]) != "v=0" {
+		t.Errorf("Invalid SAPv0 announcement: %x (%v)", b[:n], err)
+	}
+
+	a.config.OrigSrc = net.ParseIP("2001:db8::1")
+	if _, err := a.newAnnouncement(s); err == nil {
+		t.Errorf("SAPv0 announcement from an IPv6 source accepted")
+	}
+}
@@ -0,0 +1,154 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"net"
+
+	"go.mozilla.org/pkcs7"
+	"golang.org/x/crypto/openpgp"
+)
+
+// AuthStatus is the result of the verification of the authentication data of a packet
+type AuthStatus int
+
+const (
+	// AuthUnverified is the status of packets without authentication data, or whose method has no Verifier
+	AuthUnverified AuthStatus = iota
+	// AuthVerified is the status of packets with a valid signature from a trusted key
+	AuthVerified
+	// AuthInvalid is the status of packets whose signature could not be verified
+	AuthInvalid
+)
+
+func (s AuthStatus) String() string {
+	switch s {
+	case AuthUnverified:
+		return "unverified"
+	case AuthVerified:
+		return "verified"
+	case AuthInvalid:
+		return "invalid"
+	}
+	return "unknown"
+}
+
+// Verifier checks detached signatures of SAP packets
+type Verifier interface {
+	// Verify returns an error if signature is not a valid signature of data by a trusted key
+	Verify(data, signature []byte) error
+}
+
+// Verifiers associates authentication methods with their Verifier
+type Verifiers map[uint8]Verifier
+
+// PGPVerifier verifies OpenPGP signatures against a keyring
+type PGPVerifier struct {
+	Keyring openpgp.KeyRing
+}
+
+// Verify implements Verifier
+func (v PGPVerifier) Verify(data, signature []byte) error {
+	_, err := openpgp.CheckDetachedSignature(v.Keyring, bytes.NewReader(data), bytes.NewReader(signature))
+	return err
+}
+
+// CMSVerifier verifies CMS signatures against a trust store. Without Roots, only signatures by one of the Certificates
+// are trusted
+type CMSVerifier struct {
+	// Roots are the trusted certificate authorities
+	Roots *x509.CertPool
+	// Certificates are the known announcer certificates, as the authentication data is usually too small to embed them
+	Certificates []*x509.Certificate
+}
+
+// Verify implements Verifier
+func (v CMSVerifier) Verify(data, signature []byte) error {
+	if v.Roots == nil && len(v.Certificates) == 0 {
+		return errors.New("no trusted certificates")
+	}
+	p7, err := pkcs7.Parse(signature)
+	if err != nil {
+		return err
+	}
+	p7.Content = data
+	p7.Certificates = append(p7.Certificates, v.Certificates...)
+	if v.Roots != nil {
+		return p7.VerifyWithChain(v.Roots)
+	}
+	if err = p7.Verify(); err != nil {
+		return err
+	}
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return errors.New("no single signer")
+	}
+	for _, cert := range v.Certificates {
+		if cert.Equal(signer) {
+			return nil
+		}
+	}
+	return errors.New("signer certificate is not trusted")
+}
+
+// SignedData returns the part of the packet covered by the signature in the authentication data, which is the whole
+// packet without the authentication data itself
+func (p *Packet) SignedData() ([]byte, error) {
+	b := p.raw
+	if b == nil {
+		b = make([]byte, p.Length())
+		n, err := p.WriteBinary(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[:n]
+	}
+	start := 4 + net.IPv4len
+	if p.AddressType == AddrTypeV6 {
+		start = 4 + net.IPv6len
+	}
+	end := start + int(p.AuthLen)*4
+	if len(b) < end {
+		return nil, errors.New("invalid header length")
+	}
+	data := make([]byte, 0, len(b)-end+start)
+	return append(append(data, b[:start]...), b[end:]...), nil
+}
+
+// Verify checks the authentication data of the packet with the Verifier of its authentication method, and records the
+// result in the Auth field
+func (p *Packet) Verify(v Verifiers) AuthStatus {
+	p.Auth = AuthUnverified
+	if p.AuthData == nil {
+		return p.Auth
+	}
+	verifier, ok := v[p.AuthData.AuthMethod]
+	if !ok {
+		return p.Auth
+	}
+	data, err := p.SignedData()
+	if err == nil {
+		err = verifier.Verify(data, p.AuthData.Data)
+	}
+	if err != nil {
+		p.Auth = AuthInvalid
+	} else {
+		p.Auth = AuthVerified
+	}
+	return p.Auth
+}
@@ -0,0 +1,278 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pixelbender/go-sdp/sdp"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestVerifyPGP(t *testing.T) {
+	signer, err := openpgp.NewEntity("test", "", "test@example.org", nil)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+	p := Packet{
+		Header: Header{
+			Version:     1,
+			IDHash:      0xf830,
+			OrigSrc:     net.IP{192, 0, 2, 1},
+			PayloadType: SDPPayloadType,
+			AuthData:    &AuthData{Version: 1, AuthMethod: AuthMethodPGP},
+		},
+		Payload: []byte("v=0\r\n"),
+	}
+	if err = p.Sign(PGPSigner{Entity: signer}); err != nil {
+		t.Fatalf("Could not sign packet: %v", err)
+	}
+
+	if status := p.Verify(Verifiers{}); status != AuthUnverified {
+		t.Errorf("Packet without verifier is %v", status)
+	}
+	v := Verifiers{AuthMethodPGP: PGPVerifier{Keyring: openpgp.EntityList{signer}}}
+	if status := p.Verify(v); status != AuthVerified {
+		t.Errorf("Correctly signed packet is %v", status)
+	}
+	p.Payload = []byte("v=1\r\n")
+	if status := p.Verify(v); status != AuthInvalid {
+		t.Errorf("Tampered packet is %v", status)
+	}
+}
+
+func TestAuthenticatedDelete(t *testing.T) {
+	hash := SessionKey{IDHash: 0xf830}
+	channels := channelMap{lifetimes: map[SessionKey]AdvLifetime{hash: {Hash: hash.IDHash, Auth: AuthVerified}}}
+	if _, ok := channels.delete(hash, AuthUnverified); ok {
+		t.Errorf("Authenticated session deleted by an unauthenticated packet")
+	}
+	if _, ok := channels.delete(hash, AuthVerified); !ok {
+		t.Errorf("Authenticated session not deleted by an authenticated packet")
+	}
+}
+
+func newCMSSigner(t *testing.T, name string) CMSSigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Could not create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return CMSSigner{Certificate: cert, Key: key}
+}
+
+func signedCMSPacket(t *testing.T, signer CMSSigner) Packet {
+	sapp := SDPPacket{
+		Header:  Header{Version: 1, IDHash: 0xf830, OrigSrc: net.IP{192, 0, 2, 1}, PayloadType: SDPPayloadType},
+		Payload: sdp.Session{Name: "signed"},
+	}
+	if err := sapp.Sign(signer); err != nil {
+		t.Fatalf("Could not sign packet: %v", err)
+	}
+	serialized := make([]byte, sapp.Length())
+	n, err := sapp.WriteBinary(serialized)
+	if err != nil {
+		t.Fatalf("Could not write signed packet: %v", err)
+	}
+	header, err := ParseHeader(serialized[:n])
+	if err != nil {
+		t.Fatalf("Could not parse signed packet: %v", err)
+	}
+	return Packet{Header: header, Payload: serialized[header.len:n], raw: serialized[:n]}
+}
+
+func TestAuthenticatedRefresh(t *testing.T) {
+	s, err := sdp.Parse([]byte(testSDP))
+	if err != nil {
+		t.Fatalf("Could not parse test SDP: %v", err)
+	}
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime)}
+	announce := func(idHash uint16, auth AuthStatus, session sdp.Session) Event {
+		p := &DecodedPacket{Header: Header{IDHash: idHash, Auth: auth}, Payload: &SDPDescription{Session: session}}
+		return channels.refresh(SessionKey{IDHash: idHash}, p)
+	}
+	announce(1, AuthVerified, *s)
+
+	spoofed := *s
+	spoofed.Name = "spoofed"
+	for _, auth := range []AuthStatus{AuthUnverified, AuthInvalid} {
+		if ev := announce(1, auth, spoofed); ev.After.Name != s.Name || ev.After.Auth != AuthVerified {
+			t.Errorf("Authenticated session replaced by a %v packet: %+v", auth, ev.After)
+		}
+		if ev := announce(2, auth, spoofed); ev.After.Replaces != (SessionKey{}) {
+			t.Errorf("Authenticated session rehashed by a %v packet", auth)
+		}
+	}
+	if lf := channels.lifetimes[SessionKey{IDHash: 1}]; lf.Unauthenticated != 2 || lf.Count != 1 {
+		t.Errorf("Unauthenticated announcements not counted: %d, count %d", lf.Unauthenticated, lf.Count)
+	}
+	if ev := announce(1, AuthVerified, spoofed); ev.Type != SessionModified || ev.After.Name != spoofed.Name {
+		t.Errorf("Authenticated session not modified by a verified packet: %+v", ev.After)
+	}
+}
+
+func TestSpoofedSource(t *testing.T) {
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime)}
+	origSrc := net.IP{192, 0, 2, 1}
+	announce := func(src net.IP, auth AuthStatus) Event {
+		p := &DecodedPacket{Header: Header{IDHash: 1, OrigSrc: origSrc, Auth: auth},
+			Payload: &SDPDescription{Session: sdp.Session{Name: "signed"}}, Received: ReceiveInfo{Src: src}}
+		return channels.refresh(NewSessionKey(1, origSrc), p)
+	}
+	if ev := announce(origSrc, AuthVerified); ev.After.Anomalies != 0 {
+		t.Fatalf("Unexpected anomalies of the authenticated session: %v", ev.After.Anomalies)
+	}
+	ev := announce(net.IP{198, 51, 100, 1}, AuthUnverified)
+	if ev.After.Auth != AuthVerified || ev.After.Unauthenticated != 1 {
+		t.Errorf("Authenticated session replaced by the spoofed packet: %+v", ev.After)
+	}
+	expected := AnomalyOrigSrcMismatch | AnomalySourceChanged | AnomalyMultipleSources
+	if ev.After.Anomalies&expected != expected {
+		t.Errorf("Spoofed source not reported: anomalies %v, expected %v", ev.After.Anomalies, expected)
+	}
+	if len(ev.After.Sources) != 2 {
+		t.Errorf("Spoofed source not recorded: %v", ev.After.Sources)
+	}
+}
+
+func TestSignCMS(t *testing.T) {
+	signer := newCMSSigner(t, "announcer")
+	cert := signer.Certificate
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	p := signedCMSPacket(t, signer)
+	if status := p.Verify(Verifiers{AuthMethodCMS: CMSVerifier{Roots: roots}}); status != AuthVerified {
+		t.Errorf("Correctly signed packet is %v", status)
+	}
+	if status := p.Verify(Verifiers{AuthMethodCMS: CMSVerifier{Certificates: []*x509.Certificate{cert}}}); status != AuthVerified {
+		t.Errorf("Packet signed by a known certificate is %v", status)
+	}
+}
+
+func TestUntrustedCMS(t *testing.T) {
+	cert := newCMSSigner(t, "announcer").Certificate
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	// The spoofer embeds its own self-signed certificate in the signature
+	p := signedCMSPacket(t, newCMSSigner(t, "spoofer"))
+
+	for _, v := range []CMSVerifier{{Roots: roots}, {Certificates: []*x509.Certificate{cert}}, {}} {
+		if status := p.Verify(Verifiers{AuthMethodCMS: v}); status != AuthInvalid {
+			t.Errorf("Packet signed by an untrusted key is %v with %+v", status, v)
+		}
+	}
+}
+
+// lengthSigner returns zero signatures of the given successive lengths
+type lengthSigner struct {
+	lengths []int
+	signed  [][]byte
+}
+
+func (s *lengthSigner) Method() uint8 {
+	return AuthMethodCMS
+}
+
+func (s *lengthSigner) Sign(data []byte) ([]byte, error) {
+	s.signed = append(s.signed, data)
+	n := s.lengths[0]
+	if len(s.lengths) > 1 {
+		s.lengths = s.lengths[1:]
+	}
+	return make([]byte, n), nil
+}
+
+func TestSignPadding(t *testing.T) {
+	for _, c := range []struct {
+		lengths    []int
+		authLen    uint8
+		paddingLen uint8
+	}{
+		// The second signature is shorter than the length it covers, and is padded to it
+		{[]int{70, 60}, 18, 11},
+		// The padding would not fit in its length byte, the packet is signed again
+		{[]int{1000, 10}, 3, 1},
+	} {
+		signer := &lengthSigner{lengths: c.lengths}
+		p := Packet{
+			Header:  Header{Version: 1, IDHash: 0xf830, OrigSrc: net.IP{192, 0, 2, 1}, PayloadType: SDPPayloadType},
+			Payload: []byte("v=0\r\n"),
+		}
+		if err := p.Sign(signer); err != nil {
+			t.Fatalf("Could not sign packet: %v", err)
+		}
+		if p.AuthLen != c.authLen || p.AuthData.PaddingLen != c.paddingLen {
+			t.Errorf("Signatures of lengths %v padded to %d words with %d bytes", c.lengths, p.AuthLen, p.AuthData.PaddingLen)
+		}
+
+		serialized := make([]byte, p.Length())
+		n, err := p.WriteBinary(serialized)
+		if err != nil {
+			t.Fatalf("Could not write signed packet: %v", err)
+		}
+		header, err := ParseHeader(serialized[:n])
+		if err != nil {
+			t.Fatalf("Could not parse signed packet: %v", err)
+		}
+		if len(header.AuthData.Data) != c.lengths[len(c.lengths)-1] {
+			t.Errorf("Padded signature parsed with length %d", len(header.AuthData.Data))
+		}
+		parsed := Packet{Header: header, Payload: serialized[header.len:n], raw: serialized[:n]}
+		data, err := parsed.SignedData()
+		if err != nil {
+			t.Fatalf("Could not get signed data: %v", err)
+		}
+		if !bytes.Equal(data, signer.signed[len(signer.signed)-1]) {
+			t.Errorf("Signed data differs from the written packet")
+		}
+	}
+}
+
+func TestAuthDataTooLarge(t *testing.T) {
+	p := Packet{
+		Header: Header{
+			Version:     1,
+			OrigSrc:     net.IP{192, 0, 2, 1},
+			PayloadType: SDPPayloadType,
+			AuthData:    &AuthData{Version: 1, Data: make([]byte, 1024)},
+		},
+	}
+	if _, err := p.WriteBinary(make([]byte, 2048)); err != ErrAuthDataTooLarge {
+		t.Errorf("Oversized authentication data written: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"net"
+
+	"github.com/Natolumin/multidrop/mcastutil"
+)
+
+// BatchReader reads SAP packets from a Conn several datagrams at a time
+type BatchReader struct {
+	// Decryption, when set, decrypts the encrypted packets read. Packets whose key is unknown are returned encrypted
+	Decryption *Decryption
+
+	conn   *Conn
+	reader *mcastutil.BatchReader
+	packet Packet
+}
+
+// NewBatchReader creates a reader of up to batchSize datagrams of bufSize bytes per system call. When zero, they
+// default to mcastutil.DefaultBatchSize and ReadBufferSize
+func (c *Conn) NewBatchReader(batchSize, bufSize int) *BatchReader {
+	return &BatchReader{
+		conn:   c,
+		reader: mcastutil.NewBatchReader((*net.UDPConn)(c), batchSize, readBufferSize(bufSize)),
+	}
+}
+
+// Read returns the next packet like Conn.Read. The packet aliases the buffers of the reader: it is only valid until
+// the next call, and must be copied out with Clone to be kept
+func (r *BatchReader) Read() (*Packet, error) {
+	b, oob, flags, src, err := r.reader.ReadMsgUDP()
+	r.packet = Packet{}
+	if truncatedRead(err) {
+		return parseTruncated(&r.packet, b, oob, src, r.reader.BufferSize())
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := r.conn.parseDatagram(&r.packet, b, oob, flags, src, r.reader.BufferSize())
+	if err == nil {
+		err = r.Decryption.decrypt(p)
+	}
+	return p, err
+}
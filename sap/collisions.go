@@ -0,0 +1,390 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// maxStreamsPerMedia bounds the expansion of the hierarchical address and port ranges of a media
+const maxStreamsPerMedia = 256
+
+// Collision is a set of different sessions sending to the same multicast group and port, with overlapping sources
+type Collision struct {
+	Group net.IP
+	Port  int
+	// Sessions are the colliding sessions, ordered by key
+	Sessions []SessionKey
+}
+
+func (c *Collision) id() string {
+	id := net.JoinHostPort(c.Group.String(), strconv.Itoa(c.Port))
+	for _, key := range c.Sessions {
+		id += fmt.Sprintf(" %x/%x", key.OrigSrc, key.IDHash)
+	}
+	return id
+}
+
+func (c *Collision) String() string {
+	return fmt.Sprintf("%d sessions on %s", len(c.Sessions), net.JoinHostPort(c.Group.String(), strconv.Itoa(c.Port)))
+}
+
+// CollisionEventType is the type of change of a collision
+type CollisionEventType int
+
+const (
+	// CollisionDetected is reported for new collisions, and when the set of colliding sessions changes
+	CollisionDetected CollisionEventType = iota
+	// CollisionResolved is reported when a collision disappears
+	CollisionResolved
+)
+
+func (t CollisionEventType) String() string {
+	switch t {
+	case CollisionDetected:
+		return "detected"
+	case CollisionResolved:
+		return "resolved"
+	}
+	return "CollisionEventType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// CollisionEvent describes a change of the collisions found by a CollisionAnalyzer
+type CollisionEvent struct {
+	Type      CollisionEventType
+	Collision Collision
+}
+
+// CollisionAnalyzer indexes the sessions of an accumulator by connection address, port and source filter, and
+// reports the sessions colliding on the same streams
+type CollisionAnalyzer struct {
+	lock       sync.RWMutex
+	collisions map[string]Collision
+	bySession  map[SessionKey][]Collision
+
+	streams StreamsAccumulator
+	filter  ChannelFilter
+	sub     *Subscription
+	events  chan CollisionEvent
+	dropped uint64
+	done    chan struct{}
+}
+
+// NewCollisionAnalyzer analyzes the sessions of an accumulator selected by filter, or all the sessions neither
+// deleted nor expired when filter is nil, each time they change. It stops when the accumulator stops or when Close
+// is called
+func NewCollisionAnalyzer(streams StreamsAccumulator, filter ChannelFilter) *CollisionAnalyzer {
+	if filter == nil {
+		filter = func(lf *AdvLifetime) bool {
+			return lf.live()
+		}
+	}
+	a := &CollisionAnalyzer{
+		collisions: make(map[string]Collision),
+		bySession:  make(map[SessionKey][]Collision),
+		streams:    streams,
+		filter:     filter,
+		sub:        streams.Subscribe(),
+		events:     make(chan CollisionEvent, eventsBuffer),
+		done:       make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *CollisionAnalyzer) run() {
+	defer close(a.done)
+	defer close(a.events)
+	a.update(findCollisions(a.streams.Snapshot(a.filter)))
+	for a.sub.Wait() {
+		a.update(findCollisions(a.streams.Snapshot(a.filter)))
+	}
+}
+
+// update replaces the known collisions, and reports the differences as events
+func (a *CollisionAnalyzer) update(collisions []Collision) {
+	found := make(map[string]Collision, len(collisions))
+	bySession := make(map[SessionKey][]Collision)
+	for _, c := range collisions {
+		found[c.id()] = c
+		for _, key := range c.Sessions {
+			bySession[key] = append(bySession[key], c)
+		}
+	}
+
+	a.lock.Lock()
+	previous := a.collisions
+	a.collisions, a.bySession = found, bySession
+	a.lock.Unlock()
+
+	for id, c := range previous {
+		if _, ok := found[id]; !ok {
+			a.notify(CollisionEvent{Type: CollisionResolved, Collision: c})
+		}
+	}
+	for _, c := range collisions {
+		if _, ok := previous[c.id()]; !ok {
+			a.notify(CollisionEvent{Type: CollisionDetected, Collision: c})
+		}
+	}
+}
+
+// notify sends an event without blocking the analysis
+func (a *CollisionAnalyzer) notify(ev CollisionEvent) {
+	select {
+	case a.events <- ev:
+	default:
+		atomic.AddUint64(&a.dropped, 1)
+	}
+}
+
+// Collisions returns the current collisions, ordered by group and port
+func (a *CollisionAnalyzer) Collisions() []Collision {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	collisions := make([]Collision, 0, len(a.collisions))
+	for _, c := range a.collisions {
+		collisions = append(collisions, c)
+	}
+	sort.Sort(collisionSorter(collisions))
+	return collisions
+}
+
+// Of returns the current collisions of a session
+func (a *CollisionAnalyzer) Of(key SessionKey) []Collision {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.bySession[key]
+}
+
+// Filter returns a channel filter function which only returns colliding sessions
+func (a *CollisionAnalyzer) Filter() ChannelFilter {
+	return func(lf *AdvLifetime) bool {
+		return len(a.Of(lf.Key)) > 0
+	}
+}
+
+// Events returns a channel of collision changes, closed when the analyzer stops. Events are dropped when the
+// receiver is late, see Dropped
+func (a *CollisionAnalyzer) Events() <-chan CollisionEvent {
+	return a.events
+}
+
+// Dropped returns the number of events dropped because the events channel was full. The current state is always
+// available from Collisions
+func (a *CollisionAnalyzer) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Close stops the analysis and waits for it to finish
+func (a *CollisionAnalyzer) Close() {
+	a.sub.Close()
+	<-a.done
+}
+
+type collisionSorter []Collision
+
+func (s collisionSorter) Len() int      { return len(s) }
+func (s collisionSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s collisionSorter) Less(i, j int) bool {
+	if c := compareIP(s[i].Group, s[j].Group); c != 0 {
+		return c < 0
+	}
+	if s[i].Port != s[j].Port {
+		return s[i].Port < s[j].Port
+	}
+	return s[i].id() < s[j].id()
+}
+
+func compareIP(a, b net.IP) int {
+	return strings.Compare(string(a.To16()), string(b.To16()))
+}
+
+// streamKey identifies a stream by its destination
+type streamKey struct {
+	group string
+	port  int
+}
+
+// streamEntry is a session sending a stream, from the sources of its source filter or from any source when nil
+type streamEntry struct {
+	key      SessionKey
+	identity string
+	sources  []string
+}
+
+func (e *streamEntry) overlaps(o *streamEntry) bool {
+	if e.sources == nil || o.sources == nil {
+		return true
+	}
+	for _, s := range e.sources {
+		for _, os := range o.sources {
+			if s == os {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findCollisions indexes the streams of the sessions, and returns the streams sent by different sessions with
+// overlapping sources. Several announcements of the same session, eg. while a modified description replaces the
+// previous one, do not collide
+func findCollisions(sessions Sessions) []Collision {
+	sessions.Sort(byKey)
+	index := make(map[streamKey][]streamEntry)
+	var order []streamKey
+	for i := range sessions {
+		lf := &sessions[i]
+		identity, err := sessionKey(&lf.Session)
+		if err != nil {
+			identity = fmt.Sprintf("%x/%x", lf.Key.OrigSrc, lf.Key.IDHash)
+		}
+		for _, s := range sessionStreams(lf) {
+			if _, ok := index[s.key]; !ok {
+				order = append(order, s.key)
+			}
+			index[s.key] = append(index[s.key], streamEntry{key: lf.Key, identity: identity, sources: s.sources})
+		}
+	}
+
+	var collisions []Collision
+	for _, sk := range order {
+		entries := index[sk]
+		if len(entries) < 2 {
+			continue
+		}
+		var colliding []SessionKey
+		for i := range entries {
+			for j := range entries {
+				if i != j && entries[i].identity != entries[j].identity && entries[i].overlaps(&entries[j]) {
+					colliding = appendKey(colliding, entries[i].key)
+					break
+				}
+			}
+		}
+		if len(colliding) > 0 {
+			collisions = append(collisions, Collision{Group: net.ParseIP(sk.group), Port: sk.port, Sessions: colliding})
+		}
+	}
+	return collisions
+}
+
+func appendKey(keys []SessionKey, key SessionKey) []SessionKey {
+	for _, k := range keys {
+		if k == key {
+			return keys
+		}
+	}
+	return append(keys, key)
+}
+
+type sessionStream struct {
+	key     streamKey
+	sources []string
+}
+
+// sessionStreams lists the multicast streams of an SDP session, expanding the address and port ranges of its media
+func sessionStreams(lf *AdvLifetime) []sessionStream {
+	if lf.PayloadType != SDPPayloadType || lf.Encrypted {
+		return nil
+	}
+	s := &lf.Session
+	var streams []sessionStream
+	for _, m := range s.Media {
+		if m.Port == 0 {
+			continue
+		}
+		conns := m.Connection
+		if len(conns) == 0 && s.Connection != nil {
+			conns = append(conns, s.Connection)
+		}
+		ports, step := 1, 1
+		if m.PortNum > 1 {
+			ports = m.PortNum
+		}
+		if strings.Contains(m.Proto, "RTP") {
+			// RTCP uses the odd ports between RTP streams
+			step = 2
+		}
+		for _, c := range conns {
+			base := net.ParseIP(c.Address)
+			if base == nil || !base.IsMulticast() {
+				continue
+			}
+			sources := sourceFilter(m.Attributes, c.Address)
+			if sources == nil {
+				sources = sourceFilter(s.Attributes, c.Address)
+			}
+			addrs := 1
+			if c.AddressNum > 1 {
+				addrs = c.AddressNum
+			}
+			for i := 0; i < addrs && i*ports < maxStreamsPerMedia; i++ {
+				group := addIP(base, i).String()
+				for j := 0; j < ports && i*ports+j < maxStreamsPerMedia; j++ {
+					streams = append(streams, sessionStream{key: streamKey{group, m.Port + j*step}, sources: sources})
+				}
+			}
+		}
+	}
+	return streams
+}
+
+// sourceFilter returns the sources included by the RFC4570 a=source-filter attributes applying to a destination,
+// or nil when any source may send to it
+func sourceFilter(attrs sdp.Attributes, dest string) []string {
+	var sources []string
+	for _, a := range attrs {
+		if a.Name != "source-filter" {
+			continue
+		}
+		// <filter-mode> <nettype> <address-types> <dest-address> <src-list>
+		fields := strings.Fields(a.Value)
+		if len(fields) < 5 || fields[0] != "incl" || (fields[3] != dest && fields[3] != "*") {
+			continue
+		}
+		for _, src := range fields[4:] {
+			if ip := net.ParseIP(src); ip != nil {
+				src = ip.String()
+			}
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+// addIP returns the address n addresses after ip
+func addIP(ip net.IP, n int) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0 && n > 0; i-- {
+		sum := int(next[i]) + n
+		next[i] = byte(sum)
+		n = sum >> 8
+	}
+	return next
+}
@@ -0,0 +1,127 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+func collisionSession(t *testing.T, idHash uint16, origin, desc string) AdvLifetime {
+	s, err := sdp.Parse([]byte("v=0\r\no=- 1 1 IN IP4 " + origin + "\r\ns=test\r\n" + desc))
+	if err != nil {
+		t.Fatalf("Could not parse description: %v", err)
+	}
+	return AdvLifetime{Session: *s, PayloadType: SDPPayloadType, Key: SessionKey{IDHash: idHash}}
+}
+
+func TestFindCollisions(t *testing.T) {
+	const (
+		media     = "c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\n"
+		otherPort = "c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 5002 RTP/AVP 33\r\n"
+		ssm1      = "c=IN IP4 232.0.0.1/16\r\nt=0 0\r\na=source-filter: incl IN IP4 232.0.0.1 192.0.2.1\r\n" +
+			"m=video 5000 RTP/AVP 33\r\n"
+		ssm2 = "c=IN IP4 232.0.0.1/16\r\nt=0 0\r\na=source-filter: incl IN IP4 232.0.0.1 192.0.2.2\r\n" +
+			"m=video 5000 RTP/AVP 33\r\n"
+		asm      = "c=IN IP4 232.0.0.1/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\n"
+		addrs    = "c=IN IP4 233.252.0.0/16/3\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\n"
+		portsRTP = "c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 4998/2 RTP/AVP 33\r\n"
+	)
+	tests := []struct {
+		first, second string
+		sameOrigin    bool
+		group         string
+		port          int
+	}{
+		{media, media, false, "233.252.0.1", 5000},
+		{media, otherPort, false, "", 0},
+		{media, media, true, "", 0},
+		{ssm1, ssm2, false, "", 0},
+		{ssm1, ssm1, false, "232.0.0.1", 5000},
+		{ssm1, asm, false, "232.0.0.1", 5000},
+		{addrs, media, false, "233.252.0.1", 5000},
+		{portsRTP, media, false, "233.252.0.1", 5000},
+	}
+	for i, test := range tests {
+		second := "192.0.2.2"
+		if test.sameOrigin {
+			second = "192.0.2.1"
+		}
+		sessions := Sessions{
+			collisionSession(t, 1, "192.0.2.1", test.first),
+			collisionSession(t, 2, second, test.second),
+		}
+		collisions := findCollisions(sessions)
+		if test.group == "" {
+			if len(collisions) != 0 {
+				t.Errorf("%d: Unexpected collisions %v", i+1, collisions)
+			}
+			continue
+		}
+		if len(collisions) != 1 {
+			t.Errorf("%d: Expected a collision on %s:%d, got %v", i+1, test.group, test.port, collisions)
+			continue
+		}
+		c := collisions[0]
+		if c.Group.String() != test.group || c.Port != test.port || len(c.Sessions) != 2 {
+			t.Errorf("%d: Expected a collision of 2 sessions on %s:%d, got %v: %v", i+1, test.group, test.port, c.String(),
+				c.Sessions)
+		}
+	}
+}
+
+func TestCollisionAnalyzer(t *testing.T) {
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime), done: make(chan struct{}),
+		finished: make(chan struct{})}
+	analyzer := NewCollisionAnalyzer(&channels, nil)
+	const desc = "c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\n"
+
+	expect := func(typ CollisionEventType) {
+		select {
+		case ev := <-analyzer.Events():
+			if ev.Type != typ || len(ev.Collision.Sessions) != 2 {
+				t.Errorf("Expected collision %v of 2 sessions, got %v %v", typ, ev.Type, ev.Collision.Sessions)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Collision %v not reported", typ)
+		}
+	}
+
+	for i, origin := range []string{"192.0.2.1", "192.0.2.2"} {
+		lf := collisionSession(t, uint16(i+1), origin, desc)
+		p := &DecodedPacket{Header: Header{IDHash: uint16(i + 1), PayloadType: SDPPayloadType},
+			Payload: &SDPDescription{Session: lf.Session}}
+		channels.emit(channels.refresh(lf.Key, p))
+	}
+	expect(CollisionDetected)
+	lf, _ := channels.Lookup(SessionKey{IDHash: 1})
+	if !analyzer.Filter()(&lf) || len(analyzer.Collisions()) != 1 {
+		t.Errorf("Colliding session not reported: %v", analyzer.Collisions())
+	}
+
+	ev, _ := channels.delete(SessionKey{IDHash: 2}, AuthUnverified)
+	channels.emit(ev)
+	expect(CollisionResolved)
+	if analyzer.Filter()(&lf) {
+		t.Errorf("Collision of a deleted session still reported")
+	}
+
+	channels.stop(nil)
+	for range analyzer.Events() {
+	}
+	analyzer.Close()
+}
@@ -0,0 +1,83 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KeyLookup finds the keys of encrypted announcements
+type KeyLookup interface {
+	// LookupKey returns the key of the announcement with the given header, or false if it is unknown
+	LookupKey(h *Header) ([]byte, bool)
+}
+
+// Decrypter decrypts payloads with a key. RFC2974 leaves the encryption algorithm to the key distribution mechanism
+type Decrypter interface {
+	Decrypt(key, payload []byte) ([]byte, error)
+}
+
+// Decryption is a hook decrypting the encrypted packets read by a BatchReader or a StreamsAccumulator
+type Decryption struct {
+	Keys      KeyLookup
+	Decrypter Decrypter
+}
+
+// ErrNoKey is returned when decrypting a packet whose key is unknown
+var ErrNoKey = errors.New("encrypted payload without key")
+
+// decrypt decrypts a packet if d is set. Packets without key are not an error, they are reported as encrypted
+func (d *Decryption) decrypt(p *Packet) error {
+	if d == nil || !p.Encrypted {
+		return nil
+	}
+	if err := p.Decrypt(d); err != nil && err != ErrNoKey {
+		return err
+	}
+	return nil
+}
+
+// Decrypt decrypts the payload of an encrypted packet in place and sets its Decrypted flag. ErrNoKey is returned when
+// the key of the packet is unknown
+func (p *Packet) Decrypt(d *Decryption) error {
+	if !p.Encrypted || p.Decrypted {
+		return nil
+	}
+	key, ok := d.Keys.LookupKey(&p.Header)
+	if !ok {
+		return ErrNoKey
+	}
+	payload, err := d.Decrypter.Decrypt(key, p.Payload)
+	if err != nil {
+		return &ParseError{Kind: KindDecryption, Field: "payload", Offset: p.len, Packet: copyBytes(p.raw), Err: err}
+	}
+	p.Payload, p.Decrypted = payload, true
+	return nil
+}
+
+// EncryptedPayload is the Description of encrypted payloads which could not be decrypted
+type EncryptedPayload struct {
+	Data []byte
+}
+
+// SessionName implements Description, the name of encrypted sessions is unknown
+func (e *EncryptedPayload) SessionName() string {
+	return ""
+}
+
+func (e *EncryptedPayload) String() string {
+	return fmt.Sprintf("<encrypted, no key: %d bytes>", len(e.Data))
+}
@@ -0,0 +1,151 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+type testKeys map[uint16][]byte
+
+func (k testKeys) LookupKey(h *Header) ([]byte, bool) {
+	key, ok := k[h.IDHash]
+	return key, ok
+}
+
+type xorDecrypter struct{}
+
+func (xorDecrypter) Decrypt(key, payload []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("empty key")
+	}
+	plain := make([]byte, len(payload))
+	for i := range payload {
+		plain[i] = payload[i] ^ key[i%len(key)]
+	}
+	return plain, nil
+}
+
+func xor(b []byte, key byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ key
+	}
+	return out
+}
+
+func TestDecrypt(t *testing.T) {
+	d := &Decryption{Keys: testKeys{1: {0x42}, 2: {}}, Decrypter: xorDecrypter{}}
+	tests := []struct {
+		hash uint16
+		kind ErrorKind
+		name string
+	}{
+		{1, KindOther, "test"},
+		{2, KindDecryption, ""},
+		{3, KindEncrypted, ""},
+	}
+	for i, test := range tests {
+		p := &Packet{
+			Header:  Header{Version: 1, Encrypted: true, IDHash: test.hash, PayloadType: SDPPayloadType},
+			Payload: xor([]byte(testSDP), 0x42),
+		}
+		err := p.Decrypt(d)
+		if err == ErrNoKey {
+			if decoded, err := p.Decode(); err != nil {
+				t.Errorf("%d: Could not decode encrypted packet: %v", i+1, err)
+			} else if _, ok := decoded.Payload.(*EncryptedPayload); !ok {
+				t.Errorf("%d: Encrypted packet without key not reported: %v", i+1, decoded.Payload)
+			}
+			_, err = p.ParseSDP()
+		}
+		if KindOf(err) != test.kind {
+			t.Errorf("%d: Expected %v, got %v", i+1, test.kind, err)
+		}
+		if err != nil {
+			continue
+		}
+		decoded, err := p.Decode()
+		if err != nil || decoded.Payload.SessionName() != test.name {
+			t.Errorf("%d: Invalid decrypted payload: %v (%v)", i+1, decoded, err)
+		}
+	}
+
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime)}
+	p := &DecodedPacket{Header: Header{Encrypted: true}, Payload: &EncryptedPayload{Data: []byte{1, 2}}}
+	if ev := channels.refresh(SessionKey{}, p); !FilterEncrypted(&ev.After) {
+		t.Errorf("Encrypted session not tracked as such: %+v", ev.After)
+	}
+}
+
+func TestCountDecrypted(t *testing.T) {
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime), errors: make(map[ErrorKey]int)}
+	channels.SetDecryption(&Decryption{Keys: testKeys{1: {0x42}, 2: {}}, Decrypter: xorDecrypter{}})
+	src := net.IP{192, 0, 2, 1}
+	for hash := uint16(1); hash <= 3; hash++ {
+		channels.count(&Packet{
+			Header:  Header{Version: 1, Encrypted: true, IDHash: hash, OrigSrc: src, PayloadType: SDPPayloadType},
+			Payload: xor([]byte(testSDP), 0x42),
+		}, nil)
+	}
+
+	if lf, ok := channels.Lookup(NewSessionKey(1, src)); !ok || lf.Encrypted || lf.Name != "test" {
+		t.Errorf("Session not decrypted: %+v", lf)
+	}
+	if _, ok := channels.Lookup(NewSessionKey(2, src)); ok || channels.ErrorCounts()[ErrorKey{Kind: KindDecryption}] != 1 {
+		t.Errorf("Undecryptable session not counted as an error: %v", channels.ErrorCounts())
+	}
+	if lf, ok := channels.Lookup(NewSessionKey(3, src)); !ok || !lf.Encrypted {
+		t.Errorf("Session without key not recorded as encrypted: %+v", lf)
+	}
+}
+
+func TestReadDecrypted(t *testing.T) {
+	lconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("Could not listen on loopback: %v", err)
+	}
+	defer lconn.Close()
+	sender, err := net.DialUDP("udp4", nil, lconn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Could not create sender: %v", err)
+	}
+	defer sender.Close()
+
+	reader := (*Conn)(lconn).NewBatchReader(1, 0)
+	reader.Decryption = &Decryption{Keys: testKeys{1: {0x42}}, Decrypter: xorDecrypter{}}
+
+	p := Packet{
+		Header:  Header{Version: 1, Encrypted: true, IDHash: 1, OrigSrc: net.IP{192, 0, 2, 1}, PayloadType: SDPPayloadType},
+		Payload: xor([]byte(testSDP), 0x42),
+	}
+	b := make([]byte, p.Length())
+	n, err := p.WriteBinary(b)
+	if err != nil {
+		t.Fatalf("Could not write packet: %v", err)
+	}
+	if _, err = sender.Write(b[:n]); err != nil {
+		t.Fatalf("Could not send packet: %v", err)
+	}
+	received, err := reader.Read()
+	if err != nil {
+		t.Fatalf("Could not read packet: %v", err)
+	}
+	if sdpp, err := received.Clone().ParseSDP(); err != nil || sdpp.Payload.Name != "test" {
+		t.Errorf("Packet not decrypted on read: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// FieldChange is a difference in one field of two session descriptions
+type FieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+func (c FieldChange) String() string {
+	return fmt.Sprintf("%s: %q -> %q", c.Field, c.Before, c.After)
+}
+
+// SessionDiff is the list of differences between two session descriptions
+type SessionDiff []FieldChange
+
+func (d SessionDiff) String() string {
+	changes := make([]string, len(d))
+	for i, c := range d {
+		changes[i] = c.String()
+	}
+	return strings.Join(changes, ", ")
+}
+
+func (d *SessionDiff) add(field, before, after string) {
+	if before != after {
+		*d = append(*d, FieldChange{Field: field, Before: before, After: after})
+	}
+}
+
+// DiffSessions compares two session descriptions field by field
+func DiffSessions(a, b *sdp.Session) SessionDiff {
+	var d SessionDiff
+	d.add("origin", originString(a.Origin), originString(b.Origin))
+	d.add("name", a.Name, b.Name)
+	d.add("connection", connectionString(a.Connection), connectionString(b.Connection))
+	d.add("attributes", attributesString(a.Attributes), attributesString(b.Attributes))
+	d.add("media", strconv.Itoa(len(a.Media)), strconv.Itoa(len(b.Media)))
+	for i := 0; i < len(a.Media) && i < len(b.Media); i++ {
+		ma, mb := a.Media[i], b.Media[i]
+		prefix := "m[" + strconv.Itoa(i) + "]."
+		d.add(prefix+"type", ma.Type, mb.Type)
+		d.add(prefix+"port", strconv.Itoa(ma.Port), strconv.Itoa(mb.Port))
+		d.add(prefix+"proto", ma.Proto, mb.Proto)
+		d.add(prefix+"formats", formatsString(ma.Format), formatsString(mb.Format))
+		d.add(prefix+"connection", connectionsString(ma.Connection), connectionsString(mb.Connection))
+		d.add(prefix+"attributes", attributesString(ma.Attributes), attributesString(mb.Attributes))
+	}
+	return d
+}
+
+func originString(o *sdp.Origin) string {
+	if o == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s %d %d %s %s %s", o.Username, o.SessionID, o.SessionVersion, o.Network, o.Type, o.Address)
+}
+
+func connectionString(c *sdp.Connection) string {
+	if c == nil {
+		return ""
+	}
+	s := c.Address
+	if c.TTL > 0 {
+		s += "/" + strconv.Itoa(c.TTL)
+	}
+	if c.AddressNum > 1 {
+		s += "/" + strconv.Itoa(c.AddressNum)
+	}
+	return s
+}
+
+func connectionsString(conns []*sdp.Connection) string {
+	s := make([]string, len(conns))
+	for i, c := range conns {
+		s[i] = connectionString(c)
+	}
+	return strings.Join(s, " ")
+}
+
+func formatsString(formats []*sdp.Format) string {
+	s := make([]string, len(formats))
+	for i, f := range formats {
+		s[i] = strconv.Itoa(int(f.Payload))
+		if f.Name != "" {
+			s[i] += ":" + f.Name + "/" + strconv.Itoa(f.ClockRate)
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func attributesString(attrs sdp.Attributes) string {
+	s := make([]string, len(attrs))
+	for i, a := range attrs {
+		s[i] = a.Name
+		if a.Value != "" {
+			s[i] += ":" + a.Value
+		}
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,118 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+const testSDP = "v=0\r\n" +
+	"o=- 42 1 IN IP4 192.0.2.1\r\n" +
+	"s=test\r\n" +
+	"c=IN IP4 239.1.2.3/16\r\n" +
+	"t=0 0\r\n" +
+	"m=video 5004 RTP/AVP 33\r\n" +
+	"a=rtpmap:33 MP2T/90000\r\n"
+
+func TestDiffSessions(t *testing.T) {
+	a, err := sdp.Parse([]byte(testSDP))
+	if err != nil {
+		t.Fatalf("Could not parse test SDP: %v", err)
+	}
+	b, _ := sdp.Parse([]byte(testSDP))
+	if d := DiffSessions(a, b); len(d) != 0 {
+		t.Errorf("Identical sessions differ: %s", d)
+	}
+
+	b.Connection.Address = "239.1.2.4"
+	b.Media[0].Port = 5006
+	b.Media[0].Attributes = append(b.Media[0].Attributes, &sdp.Attr{Name: "recvonly"})
+	expected := SessionDiff{
+		{Field: "connection", Before: "239.1.2.3/16", After: "239.1.2.4/16"},
+		{Field: "m[0].port", Before: "5004", After: "5006"},
+		{Field: "m[0].attributes", Before: "", After: "recvonly"},
+	}
+	if diff := deep.Equal(DiffSessions(a, b), expected); diff != nil {
+		t.Errorf("Incorrect session diff: %s", diff)
+	}
+}
+
+func TestRehash(t *testing.T) {
+	s, err := sdp.Parse([]byte(testSDP))
+	if err != nil {
+		t.Fatalf("Could not parse test SDP: %v", err)
+	}
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime)}
+	first, second := SessionKey{IDHash: 1}, SessionKey{IDHash: 2}
+	channels.refresh(first, &DecodedPacket{Header: Header{IDHash: 1}, Payload: &SDPDescription{Session: *s}})
+
+	if ev := channels.refresh(second, &DecodedPacket{Header: Header{IDHash: 2}, Payload: &SDPDescription{Session: *s}}); !ev.After.SpuriousRehash {
+		t.Errorf("Unchanged session under a new hash not detected")
+	}
+
+	moved := *s
+	moved.Connection = &sdp.Connection{Network: "IN", Type: "IP4", Address: "239.1.2.4", TTL: 16}
+	ev := channels.refresh(first, &DecodedPacket{Header: Header{IDHash: 1}, Payload: &SDPDescription{Session: moved}})
+	if ev.Type != SessionModified || ev.After.SilentChanges != 1 || len(ev.After.Changes) != 1 ||
+		!FilterModified(&ev.After) {
+		t.Errorf("Silent change under the same hash not detected: %+v", ev.After)
+	}
+	ev = channels.refresh(second, &DecodedPacket{Header: Header{IDHash: 2}, Payload: &SDPDescription{Session: moved}})
+	if ev.Type != SessionModified || ev.After.SpuriousRehash {
+		t.Errorf("Spurious rehash kept after a change of the session: %+v", ev.After)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	s, err := sdp.Parse([]byte(testSDP))
+	if err != nil {
+		t.Fatalf("Could not parse test SDP: %v", err)
+	}
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime)}
+	announce := func(idHash uint16, session *sdp.Session) Event {
+		return channels.refresh(SessionKey{IDHash: idHash}, &DecodedPacket{Header: Header{IDHash: idHash},
+			Payload: &SDPDescription{Session: *session}})
+	}
+	announce(1, s)
+	announce(2, s)
+	// The second version is the most recent one
+	for i, key := range []SessionKey{{IDHash: 1}, {IDHash: 2}} {
+		lf := channels.lifetimes[key]
+		lf.Last = time.Now().Add(-time.Duration(2-i) * time.Minute)
+		channels.lifetimes[key] = lf
+	}
+
+	moved := *s
+	moved.Connection = &sdp.Connection{Network: "IN", Type: "IP4", Address: "239.1.2.4", TTL: 16}
+	ev := announce(3, &moved)
+	if ev.After.Replaces != (SessionKey{IDHash: 2}) || len(ev.After.Changes) != 1 {
+		t.Fatalf("Latest previous version not found: replaces %v, changes %v", ev.After.Replaces, ev.After.Changes)
+	}
+	replaced, ok := channels.replace(ev.After.Replaces)
+	if !ok || replaced.Type != SessionReplaced || !replaced.After.Replaced || FilterNotExpired(&replaced.After) {
+		t.Errorf("Previous version not replaced: %+v", replaced)
+	}
+	if _, ok = channels.replace(ev.After.Replaces); ok {
+		t.Errorf("Session replaced twice")
+	}
+
+	if ev = announce(3, &moved); ev.Type != SessionRefreshed || FilterModified(&ev.After) {
+		t.Errorf("Changes not cleared by an unchanged announcement: %+v", ev.After.Changes)
+	}
+}
@@ -0,0 +1,105 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import "fmt"
+
+// ErrorKind is the reason a packet could not be decoded
+type ErrorKind int
+
+const (
+	// KindOther is the kind of errors which are not parse errors, eg. network errors
+	KindOther ErrorKind = iota
+	// KindLength is reported when the packet is too short for its header
+	KindLength
+	// KindVersion is reported for unknown SAP versions
+	KindVersion
+	// KindAuthVersion is reported for unknown authentication data versions
+	KindAuthVersion
+	// KindAuthPadding is reported when the authentication padding is larger than the authentication data
+	KindAuthPadding
+	// KindPayloadType is reported when the payload type is not terminated
+	KindPayloadType
+	// KindUnsupportedPayload is reported when the payload type cannot be decoded
+	KindUnsupportedPayload
+	// KindPayload is reported when the payload cannot be decompressed or decoded
+	KindPayload
+	// KindTruncated is reported for datagrams larger than the read buffer, see TruncatedError
+	KindTruncated
+	// KindEncrypted is reported when an encrypted payload is decoded without its key
+	KindEncrypted
+	// KindDecryption is reported when an encrypted payload cannot be decrypted with its key
+	KindDecryption
+)
+
+var errorKindNames = []string{
+	KindOther:              "other error",
+	KindLength:             "invalid header length",
+	KindVersion:            "invalid SAP version",
+	KindAuthVersion:        "unsupported authentication version",
+	KindAuthPadding:        "invalid padding length",
+	KindPayloadType:        "malformed payload type",
+	KindUnsupportedPayload: "invalid payload type",
+	KindPayload:            "invalid payload",
+	KindTruncated:          "truncated datagram",
+	KindEncrypted:          "encrypted payload without key",
+	KindDecryption:         "undecryptable payload",
+}
+
+func (k ErrorKind) String() string {
+	if k < 0 || int(k) >= len(errorKindNames) {
+		return fmt.Sprintf("ErrorKind(%d)", int(k))
+	}
+	return errorKindNames[k]
+}
+
+// ParseError describes why and where a packet could not be decoded
+type ParseError struct {
+	Kind ErrorKind
+	// Field is the name of the invalid field
+	Field string
+	// Offset is the position of the invalid field in Packet
+	Offset int
+	// Packet is a copy of the raw packet, which stays valid when the buffer it was read into is reused
+	Packet []byte
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("%s (%s at byte %d)", e.Kind, e.Field, e.Offset)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// KindOf returns the kind of a parse error, or KindOther for other errors
+func KindOf(err error) ErrorKind {
+	switch err := err.(type) {
+	case *ParseError:
+		return err.Kind
+	case *TruncatedError:
+		return KindTruncated
+	}
+	return KindOther
+}
+
+// ErrorKey identifies a sender and a kind of error for error counters
+type ErrorKey struct {
+	// Src is the L3 source of the malformed packets
+	Src  string
+	Kind ErrorKind
+}
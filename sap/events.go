@@ -0,0 +1,202 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// EventType is the kind of change reported by an Event
+type EventType int
+
+const (
+	// SessionAdded is emitted when an unknown session is announced, or a deleted or expired one comes back
+	SessionAdded EventType = iota
+	// SessionRefreshed is emitted when an identical announcement of a known session is received
+	SessionRefreshed
+	// SessionModified is emitted when the SDP body of a known session changes
+	SessionModified
+	// SessionDeleted is emitted when a deletion packet is received for a known session
+	SessionDeleted
+	// SessionExpired is emitted when a session stops being announced without being deleted
+	SessionExpired
+	// SessionReplaced is emitted for the previous version of a session announced under a new message ID hash, before
+	// the SessionAdded event of the new version
+	SessionReplaced
+)
+
+var eventTypeNames = []string{
+	SessionAdded:     "added",
+	SessionRefreshed: "refreshed",
+	SessionModified:  "modified",
+	SessionDeleted:   "deleted",
+	SessionExpired:   "expired",
+	SessionReplaced:  "replaced",
+}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return "EventType(" + strconv.Itoa(int(t)) + ")"
+	}
+	return eventTypeNames[t]
+}
+
+// Event describes a change of a session in a StreamsAccumulator
+type Event struct {
+	Type EventType
+	// Before is the state of the session before the change. It is the zero value for new sessions
+	Before AdvLifetime
+	// After is the state of the session after the change
+	After AdvLifetime
+}
+
+// eventsBuffer is the number of events buffered for a subscriber before they are dropped
+const eventsBuffer = 64
+
+// Subscription receives the changes of an accumulator. Notifications never block the accumulator: change
+// notifications are coalesced, and events are dropped when the subscriber is late
+type Subscription struct {
+	changes chan struct{}
+	events  chan Event
+	dropped uint64
+
+	set    *subscriptions
+	closed bool
+}
+
+// Changes returns a channel receiving a value after sessions changed. Notifications are coalesced while the
+// subscriber is not receiving. It is closed when the accumulator stops or the subscription is closed
+func (s *Subscription) Changes() <-chan struct{} {
+	return s.changes
+}
+
+// Wait waits for a change notification, and returns false once the accumulator is stopped
+func (s *Subscription) Wait() bool {
+	_, ok := <-s.changes
+	return ok
+}
+
+// Events returns a channel of typed session changes. It is closed when the accumulator stops or the subscription is
+// closed
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the events channel was full. Subscribers dropping events
+// should resynchronize from a snapshot of the accumulator
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the notifications of the subscription and closes its channels
+func (s *Subscription) Close() {
+	s.set.subsLock.Lock()
+	defer s.set.subsLock.Unlock()
+	delete(s.set.subs, s)
+	s.close()
+}
+
+func (s *Subscription) close() {
+	if !s.closed {
+		s.closed = true
+		close(s.changes)
+		close(s.events)
+	}
+}
+
+// notify sends an event if any, and a change notification, without blocking
+func (s *Subscription) notify(ev *Event) {
+	if ev != nil {
+		select {
+		case s.events <- *ev:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+	select {
+	case s.changes <- struct{}{}:
+	default:
+	}
+}
+
+// subscriptions is the set of subscribers of an accumulator
+type subscriptions struct {
+	subsLock sync.Mutex
+	subs     map[*Subscription]struct{}
+	stopped  bool
+	// defaultSub serves WaitChange and Events
+	defaultSub *Subscription
+}
+
+func (set *subscriptions) subscribe() *Subscription {
+	set.subsLock.Lock()
+	defer set.subsLock.Unlock()
+	s := &Subscription{
+		changes: make(chan struct{}, 1),
+		events:  make(chan Event, eventsBuffer),
+		set:     set,
+	}
+	if set.stopped {
+		s.close()
+		return s
+	}
+	if set.subs == nil {
+		set.subs = make(map[*Subscription]struct{})
+	}
+	set.subs[s] = struct{}{}
+	return s
+}
+
+func (set *subscriptions) defaultSubscription() *Subscription {
+	set.subsLock.Lock()
+	s := set.defaultSub
+	set.subsLock.Unlock()
+	if s != nil {
+		return s
+	}
+	s = set.subscribe()
+	set.subsLock.Lock()
+	defer set.subsLock.Unlock()
+	if set.defaultSub != nil {
+		// Lost a race with another caller
+		delete(set.subs, s)
+		s.close()
+		return set.defaultSub
+	}
+	set.defaultSub = s
+	return s
+}
+
+// broadcast notifies all subscribers of a change, along with an event if any
+func (set *subscriptions) broadcast(ev *Event) {
+	set.subsLock.Lock()
+	defer set.subsLock.Unlock()
+	for s := range set.subs {
+		s.notify(ev)
+	}
+}
+
+// stop closes all subscriptions, and those created afterwards
+func (set *subscriptions) stop() {
+	set.subsLock.Lock()
+	defer set.subsLock.Unlock()
+	set.stopped = true
+	for s := range set.subs {
+		s.close()
+	}
+	set.subs = nil
+}
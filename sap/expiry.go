@@ -0,0 +1,147 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"errors"
+	"time"
+)
+
+// ExpiryPolicy decides when sessions stop being valid and when they are forgotten
+type ExpiryPolicy struct {
+	// IntervalFactor is the number of announcement intervals without announcement after which a session expires
+	IntervalFactor int
+	// MinTimeout is the minimum time without announcement before a session expires
+	MinTimeout time.Duration
+	// SingleTimeout, when not zero, replaces MinTimeout for sessions announced only once, whose interval is unknown
+	SingleTimeout time.Duration
+	// Tombstone is how long expired and deleted sessions are kept before being evicted
+	Tombstone time.Duration
+}
+
+// DefaultExpiryPolicy follows RFC2974: sessions expire after ten times their announcement interval or one hour,
+// whichever is greater
+var DefaultExpiryPolicy = ExpiryPolicy{
+	IntervalFactor: 10,
+	MinTimeout:     time.Hour,
+	Tombstone:      10 * time.Minute,
+}
+
+// Validate checks that the durations of the policy are not negative, and that sessions do not expire as soon as
+// they are announced. The zero ExpiryPolicy is invalid
+func (p ExpiryPolicy) Validate() error {
+	switch {
+	case p.IntervalFactor < 0 || p.MinTimeout < 0 || p.SingleTimeout < 0 || p.Tombstone < 0:
+		return errors.New("negative expiry policy")
+	case p.IntervalFactor == 0 && p.MinTimeout == 0:
+		return errors.New("expiry policy without interval factor nor minimum timeout expires all sessions")
+	}
+	return nil
+}
+
+// Timeout returns the time without announcement after which the session expires
+func (p ExpiryPolicy) Timeout(lf *AdvLifetime) time.Duration {
+	if lf.Count <= 1 && p.SingleTimeout != 0 {
+		return p.SingleTimeout
+	}
+	timeout := lf.Interval * time.Duration(p.IntervalFactor)
+	if timeout < p.MinTimeout {
+		return p.MinTimeout
+	}
+	return timeout
+}
+
+// Expired reports whether a session has timed out at the given time. Sessions with an explicit SAPv0 timeout also
+// expire at that time
+func (p ExpiryPolicy) Expired(lf *AdvLifetime, now time.Time) bool {
+	if !lf.Timeout.IsZero() && !lf.Timeout.After(now) {
+		return true
+	}
+	return !lf.Last.Add(p.Timeout(lf)).After(now)
+}
+
+// Filter returns a ChannelFilter which only returns announcements still valid according to the policy
+func (p ExpiryPolicy) Filter() ChannelFilter {
+	return func(lf *AdvLifetime) bool {
+		return lf.live() && !p.Expired(lf, time.Now())
+	}
+}
+
+// evictable reports whether a deleted, expired or replaced session has outlived its tombstone
+func (p ExpiryPolicy) evictable(lf *AdvLifetime, now time.Time) bool {
+	switch {
+	case lf.Deleted:
+		return !lf.DeletedAt.Add(p.Tombstone).After(now)
+	case lf.Expired:
+		return !lf.ExpiredAt.Add(p.Tombstone).After(now)
+	case lf.Replaced:
+		return !lf.ReplacedAt.Add(p.Tombstone).After(now)
+	}
+	return false
+}
+
+// expiryResolution is the period at which sessions are checked for expiry
+const expiryResolution = time.Second
+
+func expireStreams(channels *channelMap) {
+	defer channels.sweeper.Done()
+	ticker := time.NewTicker(expiryResolution)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-channels.done:
+			return
+		}
+		if events, changed := channels.sweep(now); len(events) > 0 || changed {
+			for _, ev := range events {
+				channels.emit(ev)
+			}
+			channels.notify()
+		}
+	}
+}
+
+// sweep marks timed out sessions as expired, evicts old tombstones and updates the interfaces sessions are missing on
+func (m *channelMap) sweep(now time.Time) (events []Event, changed bool) {
+	m.Lock()
+	defer m.Unlock()
+	for hash, channel := range m.lifetimes {
+		if m.policy.evictable(&channel, now) {
+			m.evict(hash)
+			changed = true
+			continue
+		}
+		if missing := m.missingOn(&channel, now); !sameInterfaces(missing, channel.MissingOn) {
+			channel.MissingOn = missing
+			m.lifetimes[hash] = channel
+			changed = true
+		}
+		if !channel.live() || !m.policy.Expired(&channel, now) {
+			continue
+		}
+		ev := Event{Type: SessionExpired, Before: channel}
+		channel.Expired = true
+		channel.ExpiredAt = now
+		m.lifetimes[hash] = channel
+		if m.relintNames(hash, channel.Name) {
+			changed = true
+		}
+		ev.After = channel
+		events = append(events, ev)
+	}
+	return
+}
@@ -0,0 +1,95 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiryTimeout(t *testing.T) {
+	policy := ExpiryPolicy{IntervalFactor: 10, MinTimeout: time.Hour, SingleTimeout: 2 * time.Minute}
+	tests := []struct {
+		lf      AdvLifetime
+		timeout time.Duration
+	}{
+		{AdvLifetime{Count: 1}, 2 * time.Minute},
+		{AdvLifetime{Count: 5, Interval: time.Minute}, time.Hour},
+		{AdvLifetime{Count: 5, Interval: 10 * time.Minute}, 100 * time.Minute},
+	}
+	for i, test := range tests {
+		if timeout := policy.Timeout(&test.lf); timeout != test.timeout {
+			t.Errorf("%d: Expected timeout %v, got %v", i+1, test.timeout, timeout)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Now()
+	live, stale, tombstone := SessionKey{IDHash: 1}, SessionKey{IDHash: 2}, SessionKey{IDHash: 3}
+	channels := channelMap{
+		policy: DefaultExpiryPolicy,
+		lifetimes: map[SessionKey]AdvLifetime{
+			live:      {Hash: 1, Last: now, Count: 1},
+			stale:     {Hash: 2, Last: now.Add(-2 * time.Hour), Count: 1},
+			tombstone: {Hash: 3, Last: now.Add(-2 * time.Hour), Deleted: true, DeletedAt: now.Add(-time.Hour)},
+		},
+	}
+	events, evicted := channels.sweep(now)
+	if !evicted {
+		t.Errorf("Old tombstone not evicted")
+	}
+	if _, ok := channels.lifetimes[tombstone]; ok {
+		t.Errorf("Evicted session still present")
+	}
+	if len(events) != 1 || events[0].Type != SessionExpired || events[0].After.Hash != stale.IDHash {
+		t.Fatalf("Expected one expiry event for the stale session, got %+v", events)
+	}
+	if lf := channels.lifetimes[stale]; !lf.Expired || FilterNotExpired(&lf) {
+		t.Errorf("Stale session not marked expired")
+	}
+	if events, _ = channels.sweep(now); len(events) != 0 {
+		t.Errorf("Session expired twice")
+	}
+}
+
+func TestSetExpiryPolicy(t *testing.T) {
+	tests := []struct {
+		policy ExpiryPolicy
+		valid  bool
+	}{
+		{DefaultExpiryPolicy, true},
+		{ExpiryPolicy{IntervalFactor: 10, SingleTimeout: 2 * time.Minute, Tombstone: 2 * time.Minute}, true},
+		{ExpiryPolicy{MinTimeout: time.Hour}, true},
+		{ExpiryPolicy{}, false},
+		{ExpiryPolicy{Tombstone: time.Minute}, false},
+		{ExpiryPolicy{IntervalFactor: 10, MinTimeout: -time.Second}, false},
+		{ExpiryPolicy{IntervalFactor: -1, MinTimeout: time.Hour}, false},
+	}
+	for i, test := range tests {
+		channels := channelMap{policy: DefaultExpiryPolicy}
+		err := channels.SetExpiryPolicy(test.policy)
+		if (err == nil) != test.valid {
+			t.Errorf("%d: Expected valid %v for %+v, got %v", i+1, test.valid, test.policy, err)
+		}
+		expected := DefaultExpiryPolicy
+		if test.valid {
+			expected = test.policy
+		}
+		if channels.policy != expected {
+			t.Errorf("%d: Expected policy %+v, got %+v", i+1, expected, channels.policy)
+		}
+	}
+}
@@ -34,7 +34,51 @@ func ChannelList(channels []string) ChannelFilter {
 
 // FilterNotExpired is a channel filter function which only returns still-valid announcements wrt RFC2974
 func FilterNotExpired(lf *AdvLifetime) bool {
-	return lf.Last.Add(lf.Interval*10).After(time.Now()) || lf.Last.Add(time.Hour).After(time.Now())
+	return lf.live() && !DefaultExpiryPolicy.Expired(lf, time.Now())
+}
+
+// FilterDeleted is a channel filter function which only returns announcements withdrawn by a deletion packet
+func FilterDeleted(lf *AdvLifetime) bool {
+	return lf.Deleted
+}
+
+// FilterModified is a channel filter function which only returns sessions whose description changed, or whose message
+// ID hash changed without a change in the description
+func FilterModified(lf *AdvLifetime) bool {
+	return len(lf.Changes) > 0 || lf.SpuriousRehash
+}
+
+// FilterAuthenticated is a channel filter function which drops sessions not authenticated by a trusted key
+func FilterAuthenticated(lf *AdvLifetime) bool {
+	return lf.Auth == AuthVerified
+}
+
+// FilterEncrypted is a channel filter function which only returns encrypted sessions whose key is unknown
+func FilterEncrypted(lf *AdvLifetime) bool {
+	return lf.Encrypted
+}
+
+// FilterMissing is a channel filter function which only returns sessions missing on some of the interfaces
+func FilterMissing(lf *AdvLifetime) bool {
+	return len(lf.MissingOn) > 0
+}
+
+// FilterMisScoped is a channel filter function which only returns sessions announced in another scope than the one of
+// their connection address
+func FilterMisScoped(lf *AdvLifetime) bool {
+	return lf.MisScoped
+}
+
+// FilterLinted is a channel filter function which only returns sessions with problems in their description
+func FilterLinted(lf *AdvLifetime) bool {
+	return len(lf.Lints) > 0
+}
+
+// FilterSourceAnomalies returns a channel filter which only returns sessions with one of the given source anomalies
+func FilterSourceAnomalies(mask SourceAnomaly) ChannelFilter {
+	return func(lf *AdvLifetime) bool {
+		return lf.Anomalies&mask != 0
+	}
 }
 
 // FilterAnd combines two ChannelFilter as a logical and
@@ -0,0 +1,70 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+// sessionIndex indexes the sessions of an accumulator by a description attribute
+type sessionIndex map[string]map[SessionKey]struct{}
+
+func (i sessionIndex) add(key string, hash SessionKey) {
+	if key == "" {
+		return
+	}
+	if i[key] == nil {
+		i[key] = make(map[SessionKey]struct{})
+	}
+	i[key][hash] = struct{}{}
+}
+
+func (i sessionIndex) remove(key string, hash SessionKey) {
+	if set := i[key]; set != nil {
+		delete(set, hash)
+		if len(set) == 0 {
+			delete(i, key)
+		}
+	}
+}
+
+// originKey returns the SDP origin of a session without its version, which identifies it across changes, or an empty
+// string when it has none
+func originKey(lf *AdvLifetime) string {
+	key, _ := sessionKey(&lf.Session)
+	return key
+}
+
+// store records the state of a session, and indexes its description
+func (m *channelMap) store(hash SessionKey, lf AdvLifetime) {
+	if m.origins == nil {
+		m.origins = make(sessionIndex)
+	}
+	if m.names == nil {
+		m.names = make(sessionIndex)
+	}
+	if before, ok := m.lifetimes[hash]; ok {
+		m.origins.remove(originKey(&before), hash)
+		m.names.remove(before.Name, hash)
+	}
+	m.lifetimes[hash] = lf
+	m.origins.add(originKey(&lf), hash)
+	m.names.add(lf.Name, hash)
+}
+
+// evict forgets a session
+func (m *channelMap) evict(hash SessionKey) {
+	if lf, ok := m.lifetimes[hash]; ok {
+		m.origins.remove(originKey(&lf), hash)
+		m.names.remove(lf.Name, hash)
+		delete(m.lifetimes, hash)
+	}
+}
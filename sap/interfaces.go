@@ -0,0 +1,156 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// InterfaceSeen is an interface on which announcements were received
+type InterfaceSeen struct {
+	// Name is the name of the interface, or its index if it has no name, or empty if unknown
+	Name  string
+	Last  time.Time
+	Count int
+}
+
+// CountStreamsOn starts an accumulator fed by several connections, eg. each bound to a different interface. As with
+// CountStreams, the interfaces each session is received on are recorded, and sessions missing on some of the active
+// interfaces are reported in MissingOn. Interfaces are named in the network namespace of the process, so all the
+// connections must belong to it. The connections should not receive the same packets: a single connection
+// joined on several interfaces with mcastutil.ListenMulticastUDPOn is preferable when possible. The accumulator
+// stops once all connections are closed, Err then returns the first read error
+func CountStreamsOn(conns ...*SDPConn) StreamsAccumulator {
+	channels := newChannelMap(conns...)
+	errs := make(chan error, len(conns))
+	var readers sync.WaitGroup
+	for _, c := range conns {
+		readers.Add(1)
+		go func(c *SDPConn) {
+			defer readers.Done()
+			errs <- countStreams(channels, c)
+		}(c)
+	}
+	go func() {
+		readers.Wait()
+		close(errs)
+		channels.stop(<-errs)
+	}()
+	return channels
+}
+
+// activityWindow is the time after which a session not announced by a source or on an interface is considered gone
+// from it
+func activityWindow(lf *AdvLifetime) time.Duration {
+	window := 3 * lf.Interval
+	if window < minSourceWindow {
+		window = minSourceWindow
+	}
+	return window
+}
+
+// interfaceName returns the name of an interface, cached as looking it up needs a system call. It must be called with
+// the accumulator locked
+func (m *channelMap) interfaceName(index int) string {
+	if index == 0 {
+		return ""
+	}
+	if name, ok := m.ifnames[index]; ok {
+		return name
+	}
+	name := strconv.Itoa(index)
+	if ifi, err := net.InterfaceByIndex(index); err == nil {
+		name = ifi.Name
+	}
+	if m.ifnames == nil {
+		m.ifnames = make(map[int]string)
+	}
+	m.ifnames[index] = name
+	return name
+}
+
+// trackInterface records the interface of an announcement, in the session and in the accumulator totals. It must be
+// called with the accumulator locked
+func (m *channelMap) trackInterface(lf *AdvLifetime, ifname string, now time.Time) {
+	lf.Interfaces = seenOn(lf.Interfaces, ifname, now)
+	m.interfaces = seenOn(m.interfaces, ifname, now)
+}
+
+// seenOn returns a copy of interfaces with an announcement on ifname, as AdvLifetime values are shared with consumers
+func seenOn(interfaces []InterfaceSeen, ifname string, now time.Time) []InterfaceSeen {
+	updated := make([]InterfaceSeen, len(interfaces), len(interfaces)+1)
+	copy(updated, interfaces)
+	for i := range updated {
+		if updated[i].Name == ifname {
+			updated[i].Last = now
+			updated[i].Count++
+			return updated
+		}
+	}
+	return append(updated, InterfaceSeen{Name: ifname, Last: now, Count: 1})
+}
+
+// missingOn returns the active interfaces of the accumulator on which a session has not been received recently. It
+// must be called with the accumulator locked
+func (m *channelMap) missingOn(lf *AdvLifetime, now time.Time) []string {
+	if len(m.interfaces) < 2 || !lf.live() {
+		return nil
+	}
+	window := activityWindow(lf)
+	if now.Sub(lf.First) < window {
+		// Give the session time to be received on all interfaces
+		return nil
+	}
+	var missing []string
+	for _, ifs := range m.interfaces {
+		if now.Sub(ifs.Last) >= window {
+			continue
+		}
+		found := false
+		for _, seen := range lf.Interfaces {
+			if seen.Name == ifs.Name {
+				found = now.Sub(seen.Last) < window
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, ifs.Name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func sameInterfaces(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *channelMap) Interfaces() []InterfaceSeen {
+	m.RLock()
+	defer m.RUnlock()
+	return append([]InterfaceSeen(nil), m.interfaces...)
+}
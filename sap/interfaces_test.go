@@ -0,0 +1,78 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMissingOn(t *testing.T) {
+	channels := channelMap{
+		lifetimes: make(map[SessionKey]AdvLifetime),
+		ifnames:   map[int]string{1: "eth0", 2: "eth1", 3: "eth2"},
+		policy:    DefaultExpiryPolicy,
+	}
+	announce := func(hash uint16, ifindex int) {
+		channels.refresh(SessionKey{IDHash: hash}, &DecodedPacket{Header: Header{IDHash: hash},
+			Payload: &SDPDescription{}, Received: ReceiveInfo{IfIndex: ifindex}})
+	}
+	announce(1, 1)
+	announce(1, 2)
+	announce(2, 1)
+	announce(3, 3)
+	announce(3, 3)
+
+	lf := channels.lifetimes[SessionKey{IDHash: 1}]
+	expected := []InterfaceSeen{{Name: "eth0", Last: lf.Interfaces[0].Last, Count: 1},
+		{Name: "eth1", Last: lf.Interfaces[1].Last, Count: 1}}
+	if !reflect.DeepEqual(lf.Interfaces, expected) {
+		t.Errorf("Invalid session interfaces: %+v", lf.Interfaces)
+	}
+	if totals := channels.Interfaces(); len(totals) != 3 || totals[0].Count != 2 || totals[2].Count != 2 {
+		t.Errorf("Invalid interface totals: %+v", totals)
+	}
+
+	if _, changed := channels.sweep(time.Now()); changed {
+		t.Errorf("New sessions reported missing before being announced on all interfaces")
+	}
+	now := lf.First.Add(activityWindow(&lf) - time.Second)
+	// Make the announcements old enough, keeping all interfaces active
+	for key, lf := range channels.lifetimes {
+		lf.First = lf.First.Add(-time.Hour)
+		channels.lifetimes[key] = lf
+	}
+	if _, changed := channels.sweep(now); !changed {
+		t.Errorf("Missing sessions not reported")
+	}
+	tests := []struct {
+		hash    uint16
+		missing []string
+	}{
+		{1, []string{"eth2"}},
+		{2, []string{"eth1", "eth2"}},
+		{3, []string{"eth0", "eth1"}},
+	}
+	for i, test := range tests {
+		missing := channels.lifetimes[SessionKey{IDHash: test.hash}].MissingOn
+		if !reflect.DeepEqual(missing, test.missing) {
+			t.Errorf("%d: Expected session missing on %v, got %v", i+1, test.missing, missing)
+		}
+	}
+	if _, changed := channels.sweep(now); changed {
+		t.Errorf("Unchanged missing interfaces reported as changed")
+	}
+}
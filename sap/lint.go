@@ -0,0 +1,280 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// LintKind is the kind of problem found in a session description
+type LintKind int
+
+const (
+	// LintNoConnection is reported when neither the session nor all its media have a connection (c=) line
+	LintNoConnection LintKind = iota
+	// LintUnicastConnection is reported for connection addresses which are not multicast addresses
+	LintUnicastConnection
+	// LintNoTTL is reported for IPv4 multicast connection addresses without TTL
+	LintNoTTL
+	// LintPortZero is reported for media on port 0
+	LintPortZero
+	// LintDuplicatePort is reported for media sharing the connection address and port of a previous media
+	LintDuplicatePort
+	// LintUnknownPayloadType is reported for RTP payload types which are neither static nor mapped by a=rtpmap, and
+	// for a=rtpmap of payload types the media does not use
+	LintUnknownPayloadType
+	// LintSourceFilter is reported for a=source-filter attributes which exclude the origin address, or include
+	// sources of the origin address type but not the origin address
+	LintSourceFilter
+	// LintDuplicateName is reported for sessions with the same name as a session of another origin
+	LintDuplicateName
+)
+
+var lintKindNames = []string{
+	LintNoConnection:       "missing connection",
+	LintUnicastConnection:  "non-multicast connection address",
+	LintNoTTL:              "missing TTL",
+	LintPortZero:           "port 0",
+	LintDuplicatePort:      "duplicate port",
+	LintUnknownPayloadType: "unknown payload type",
+	LintSourceFilter:       "inconsistent source filter",
+	LintDuplicateName:      "duplicate session name",
+}
+
+func (k LintKind) String() string {
+	if k < 0 || int(k) >= len(lintKindNames) {
+		return fmt.Sprintf("LintKind(%d)", int(k))
+	}
+	return lintKindNames[k]
+}
+
+// Lint is a problem found in a session description
+type Lint struct {
+	Kind LintKind
+	// Media is the index of the offending media, or -1 for the session level
+	Media int
+	// Detail describes the offending value
+	Detail string
+}
+
+func (l Lint) String() string {
+	msg := l.Kind.String()
+	if l.Media >= 0 {
+		msg = fmt.Sprintf("media %d: %s", l.Media, msg)
+	}
+	if l.Detail != "" {
+		msg += ": " + l.Detail
+	}
+	return msg
+}
+
+// staticPayloadTypes are the RTP payload types assigned by RFC3551, which need no a=rtpmap
+var staticPayloadTypes = map[uint8]bool{
+	0: true, 3: true, 4: true, 5: true, 6: true, 7: true, 8: true, 9: true, 10: true, 11: true, 12: true, 13: true,
+	14: true, 15: true, 16: true, 17: true, 18: true, 25: true, 26: true, 28: true, 31: true, 32: true, 33: true,
+	34: true,
+}
+
+// LintSession checks a session description for problems which break receivers. Duplicate names are not checked, as
+// they depend on the other announced sessions, see AdvLifetime.Lints
+func LintSession(s *sdp.Session) []Lint {
+	var lints []Lint
+	if s.Connection != nil {
+		lints = lintConnection(lints, s.Connection, -1)
+	}
+	lints = lintSourceFilters(lints, s.Attributes, s.Origin, -1)
+	if s.Connection == nil && len(s.Media) == 0 {
+		lints = append(lints, Lint{Kind: LintNoConnection, Media: -1})
+	}
+
+	ports := make(map[string]int)
+	for i, m := range s.Media {
+		conns := m.Connection
+		if len(conns) == 0 {
+			if s.Connection == nil {
+				lints = append(lints, Lint{Kind: LintNoConnection, Media: i})
+				continue
+			}
+			conns = []*sdp.Connection{s.Connection}
+		}
+		for _, c := range m.Connection {
+			lints = lintConnection(lints, c, i)
+		}
+		lints = lintSourceFilters(lints, m.Attributes, s.Origin, i)
+		lints = lintPayloadTypes(lints, m, i)
+		if m.Port == 0 {
+			lints = append(lints, Lint{Kind: LintPortZero, Media: i})
+			continue
+		}
+		for _, c := range conns {
+			addr := net.JoinHostPort(c.Address, strconv.Itoa(m.Port))
+			if first, ok := ports[addr]; ok {
+				lints = append(lints, Lint{Kind: LintDuplicatePort, Media: i,
+					Detail: fmt.Sprintf("%s already used by media %d", addr, first)})
+				continue
+			}
+			ports[addr] = i
+		}
+	}
+	return lints
+}
+
+func lintConnection(lints []Lint, c *sdp.Connection, media int) []Lint {
+	ip := net.ParseIP(c.Address)
+	if ip == nil || !ip.IsMulticast() {
+		return append(lints, Lint{Kind: LintUnicastConnection, Media: media, Detail: c.Address})
+	}
+	if ip.To4() != nil && c.TTL == 0 {
+		return append(lints, Lint{Kind: LintNoTTL, Media: media, Detail: c.Address})
+	}
+	return lints
+}
+
+func lintPayloadTypes(lints []Lint, m *sdp.Media, media int) []Lint {
+	if !strings.Contains(m.Proto, "RTP") {
+		return lints
+	}
+	used := make(map[uint8]bool, len(m.Format))
+	for _, f := range m.Format {
+		used[f.Payload] = true
+		if f.Name == "" && !staticPayloadTypes[f.Payload] {
+			lints = append(lints, Lint{Kind: LintUnknownPayloadType, Media: media,
+				Detail: fmt.Sprintf("%d has no rtpmap", f.Payload)})
+		}
+	}
+	for _, a := range m.Attributes {
+		if a.Name != "rtpmap" {
+			continue
+		}
+		fields := strings.Fields(a.Value)
+		if len(fields) == 0 {
+			continue
+		}
+		if pt, err := strconv.ParseUint(fields[0], 10, 8); err != nil || !used[uint8(pt)] {
+			lints = append(lints, Lint{Kind: LintUnknownPayloadType, Media: media,
+				Detail: fmt.Sprintf("rtpmap of unused payload type %s", fields[0])})
+		}
+	}
+	return lints
+}
+
+// lintSourceFilters checks RFC4570 a=source-filter attributes against the origin address
+func lintSourceFilters(lints []Lint, attrs sdp.Attributes, origin *sdp.Origin, media int) []Lint {
+	if origin == nil {
+		return lints
+	}
+	originIP := net.ParseIP(origin.Address)
+	for _, a := range attrs {
+		if a.Name != "source-filter" {
+			continue
+		}
+		// <filter-mode> <nettype> <address-types> <dest-address> <src-list>
+		fields := strings.Fields(a.Value)
+		if len(fields) < 5 {
+			lints = append(lints, Lint{Kind: LintSourceFilter, Media: media, Detail: "malformed " + a.Value})
+			continue
+		}
+		if fields[1] != origin.Network || (fields[2] != origin.Type && fields[2] != "*") {
+			continue
+		}
+		listed := false
+		for _, src := range fields[4:] {
+			if src == origin.Address || (originIP != nil && originIP.Equal(net.ParseIP(src))) {
+				listed = true
+			}
+		}
+		switch {
+		case fields[0] == "incl" && !listed:
+			lints = append(lints, Lint{Kind: LintSourceFilter, Media: media,
+				Detail: fmt.Sprintf("origin %s not included", origin.Address)})
+		case fields[0] == "excl" && listed:
+			lints = append(lints, Lint{Kind: LintSourceFilter, Media: media,
+				Detail: fmt.Sprintf("origin %s excluded", origin.Address)})
+		}
+	}
+	return lints
+}
+
+// lint checks the description of a session, and whether a live session from another origin has the same name
+func (m *channelMap) lint(hash SessionKey, lf *AdvLifetime) []Lint {
+	if lf.PayloadType != SDPPayloadType || lf.Encrypted {
+		return nil
+	}
+	lints := LintSession(&lf.Session)
+	if lf.Name == "" {
+		return lints
+	}
+	key, _ := sessionKey(&lf.Session)
+	var duplicate *Lint
+	for h := range m.names[lf.Name] {
+		channel := m.lifetimes[h]
+		if h == hash || !channel.live() || channel.PayloadType != SDPPayloadType {
+			continue
+		}
+		if k, _ := sessionKey(&channel.Session); k != key || key == "" {
+			detail := lf.Name
+			if channel.Origin != nil {
+				detail = fmt.Sprintf("%q also announced by %s", lf.Name, channel.Origin.Address)
+			}
+			// The same duplicate is reported whatever the iteration order, so that the lints only change with it
+			if duplicate == nil || detail < duplicate.Detail {
+				duplicate = &Lint{Kind: LintDuplicateName, Media: -1, Detail: detail}
+			}
+		}
+	}
+	if duplicate != nil {
+		lints = append(lints, *duplicate)
+	}
+	return lints
+}
+
+// relintNames updates the problems of the live sessions other than hash with one of the given names, whose
+// duplicates may have changed. It reports whether any session was updated
+func (m *channelMap) relintNames(hash SessionKey, names ...string) (changed bool) {
+	for i, name := range names {
+		if name == "" || (i > 0 && name == names[0]) {
+			continue
+		}
+		for h := range m.names[name] {
+			channel := m.lifetimes[h]
+			if h == hash || !channel.live() {
+				continue
+			}
+			if lints := m.lint(h, &channel); !sameLints(lints, channel.Lints) {
+				channel.Lints = lints
+				m.lifetimes[h] = channel
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func sameLints(a, b []Lint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
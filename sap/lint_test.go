@@ -0,0 +1,105 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"testing"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+const lintOrigin = "v=0\r\no=- 1 1 IN IP4 192.0.2.1\r\ns=test\r\n"
+
+func TestLintSession(t *testing.T) {
+	tests := []struct {
+		desc  string
+		kinds []LintKind
+	}{
+		{"c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\n", nil},
+		{"t=0 0\r\nm=video 5000 RTP/AVP 33\r\n", []LintKind{LintNoConnection}},
+		{"t=0 0\r\nm=video 5000 RTP/AVP 33\r\nc=IN IP4 233.252.0.1/16\r\n", nil},
+		{"c=IN IP4 192.0.2.2/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\n", []LintKind{LintUnicastConnection}},
+		{"c=IN IP4 233.252.0.1\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\n", []LintKind{LintNoTTL}},
+		{"c=IN IP6 ff0e::1\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\n", nil},
+		{"c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 0 RTP/AVP 33\r\n", []LintKind{LintPortZero}},
+		{"c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\nm=audio 5000 RTP/AVP 14\r\n",
+			[]LintKind{LintDuplicatePort}},
+		{"c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\nm=audio 5000 RTP/AVP 14\r\n" +
+			"c=IN IP4 233.252.0.2/16\r\n", nil},
+		{"c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 96\r\n", []LintKind{LintUnknownPayloadType}},
+		{"c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n", nil},
+		{"c=IN IP4 233.252.0.1/16\r\nt=0 0\r\nm=video 5000 udp mpeg 96\r\n", nil},
+		{"c=IN IP4 232.0.0.1/16\r\nt=0 0\r\na=source-filter: incl IN IP4 232.0.0.1 192.0.2.1\r\n" +
+			"m=video 5000 RTP/AVP 33\r\n", nil},
+		{"c=IN IP4 232.0.0.1/16\r\nt=0 0\r\na=source-filter: incl IN IP4 232.0.0.1 192.0.2.9\r\n" +
+			"m=video 5000 RTP/AVP 33\r\n", []LintKind{LintSourceFilter}},
+		{"c=IN IP4 232.0.0.1/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\n" +
+			"a=source-filter: excl IN IP4 232.0.0.1 192.0.2.1\r\n", []LintKind{LintSourceFilter}},
+		{"c=IN IP4 232.0.0.1/16\r\nt=0 0\r\nm=video 5000 RTP/AVP 33\r\n" +
+			"a=source-filter: incl IN IP6 ff3e::1 2001:db8::1\r\n", nil},
+	}
+	for i, test := range tests {
+		s, err := sdp.Parse([]byte(lintOrigin + test.desc))
+		if err != nil {
+			t.Errorf("%d: Could not parse description: %v", i+1, err)
+			continue
+		}
+		lints := LintSession(s)
+		if len(lints) != len(test.kinds) {
+			t.Errorf("%d: Expected %v, got %v", i+1, test.kinds, lints)
+			continue
+		}
+		for j, lint := range lints {
+			if lint.Kind != test.kinds[j] {
+				t.Errorf("%d: Expected %v, got %v", i+1, test.kinds[j], lint)
+			}
+		}
+	}
+}
+
+func TestLintDuplicateName(t *testing.T) {
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime)}
+	announce := func(idHash uint16, origin string) Event {
+		desc := &SDPDescription{Session: sdp.Session{
+			Origin:     &sdp.Origin{Username: "-", SessionID: 1, Network: "IN", Type: "IP4", Address: origin},
+			Name:       "test",
+			Connection: &sdp.Connection{Network: "IN", Type: "IP4", Address: "233.252.0.1", TTL: 16},
+		}}
+		p := &DecodedPacket{Header: Header{IDHash: idHash, PayloadType: SDPPayloadType}, Payload: desc}
+		return channels.refresh(SessionKey{IDHash: idHash}, p)
+	}
+
+	if ev := announce(1, "192.0.2.1"); len(ev.After.Lints) != 0 {
+		t.Errorf("Unexpected lints for a single session: %v", ev.After.Lints)
+	}
+	if ev := announce(2, "192.0.2.1"); len(ev.After.Lints) != 0 {
+		t.Errorf("New version of a session reported as duplicate: %v", ev.After.Lints)
+	}
+	channels.delete(SessionKey{IDHash: 2}, AuthUnverified)
+	ev := announce(3, "192.0.2.2")
+	if len(ev.After.Lints) != 1 || ev.After.Lints[0].Kind != LintDuplicateName {
+		t.Errorf("Duplicate name not reported: %v", ev.After.Lints)
+	}
+	if lf, _ := channels.Lookup(SessionKey{IDHash: 1}); len(lf.Lints) != 1 || lf.Lints[0].Kind != LintDuplicateName {
+		t.Errorf("Duplicate name not reported on the existing session: %v", lf.Lints)
+	}
+	if ev := announce(1, "192.0.2.1"); len(ev.After.Lints) != 1 || ev.After.Lints[0].Kind != LintDuplicateName {
+		t.Errorf("Duplicate name not reported on refresh: %v", ev.After.Lints)
+	}
+	channels.delete(SessionKey{IDHash: 3}, AuthUnverified)
+	if lf, _ := channels.Lookup(SessionKey{IDHash: 1}); len(lf.Lints) != 0 {
+		t.Errorf("Duplicate name still reported after its deletion: %v", lf.Lints)
+	}
+}
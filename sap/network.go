@@ -16,10 +16,13 @@
 package sap
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/Natolumin/multidrop/mcastutil"
 	"github.com/pixelbender/go-sdp/sdp"
 )
 
@@ -35,8 +38,26 @@ var (
 	GroupAddr6 = net.IP{0xff, 0x08, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x02, 0x7f, 0xfe}
 
 	maxMTU = 1500
+
+	// ReadBufferSize is the default size of the buffer packets are read into, for Read and for readers given a zero
+	// size. Larger packets are reported with a TruncatedError. When zero, the largest MTU of the interfaces is used,
+	// set it to MaxDatagramSize to accept fragmented announcements
+	ReadBufferSize = 0
 )
 
+// MaxDatagramSize is the size of the largest possible UDP datagram after reassembly
+const MaxDatagramSize = 1 << 16
+
+// TruncatedError is returned when a datagram is larger than the read buffer. The header of the packet is still parsed
+// if possible, but not its payload
+type TruncatedError struct {
+	BufferSize int
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("truncated datagram larger than the %d bytes buffer", e.BufferSize)
+}
+
 func init() {
 	ifaces, err := net.Interfaces()
 	if err != nil { // Assume ethernet MTU
@@ -64,25 +85,88 @@ var DefaultSAPGroups = []net.IP{
 	V6GroupByZone(8),
 	V6GroupByZone(0xe),
 	GroupAddr4,
+	IPv4AdminScopes[0].Group,
+	IPv4AdminScopes[1].Group,
 }
 
 // Conn implements ReadCloser for SAP packets
 type Conn net.UDPConn
 
+// Read reads a packet. Encrypted packets are returned as is, see Packet.Decrypt
 func (c *Conn) Read() (p *Packet, err error) {
-	b := make([]byte, maxMTU)
+	return c.ReadSize(0)
+}
+
+// ReadSize reads a packet like Read into a buffer of bufSize bytes, or ReadBufferSize if zero. Larger packets are
+// reported with a TruncatedError
+func (c *Conn) ReadSize(bufSize int) (*Packet, error) {
+	return c.readInto(new(Packet), make([]byte, readBufferSize(bufSize)), make([]byte, mcastutil.OOBSize))
+}
+
+// ReadContext reads a packet like Read, or returns ctx.Err() if ctx is done before a packet is received
+func (c *Conn) ReadContext(ctx context.Context) (*Packet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn := (*net.UDPConn)(c)
+	stop := mcastutil.InterruptOnDone(ctx, conn)
+	p, err := c.Read()
+	stop()
+	if ctx.Err() != nil {
+		// Clear the deadline set on cancellation for the next reads
+		_ = conn.SetReadDeadline(time.Time{})
+		if p == nil {
+			return nil, ctx.Err()
+		}
+	}
+	return p, err
+}
 
-	n, err := (*net.UDPConn)(c).Read(b)
-	//XXX: Do I want to handle truncated reads ?
+// readBufferSize returns the size of the read buffers of a reader given bufSize
+func readBufferSize(bufSize int) int {
+	if bufSize > 0 {
+		return bufSize
+	}
+	if ReadBufferSize > 0 {
+		return ReadBufferSize
+	}
+	return maxMTU
+}
+
+// readInto reads a packet into the given buffers. The returned packet is nil if nothing could be read
+func (c *Conn) readInto(p *Packet, b, oob []byte) (*Packet, error) {
+	n, oobn, flags, src, err := (*net.UDPConn)(c).ReadMsgUDP(b, oob)
+	if truncatedRead(err) {
+		return parseTruncated(p, b[:n], oob[:oobn], src, len(b))
+	}
 	if err != nil {
-		return
+		return nil, err
 	}
-	p = new(Packet)
+	return c.parseDatagram(p, b[:n], oob[:oobn], flags, src, len(b))
+}
+
+// parseDatagram parses a datagram read into a buffer of bufSize bytes
+func (c *Conn) parseDatagram(p *Packet, b, oob []byte, flags int, src *net.UDPAddr, bufSize int) (*Packet, error) {
+	var err error
+	p.Received = parseReceiveInfo(oob, src)
 
-	if p.Header, err = ParseHeader(b[:n]); err == nil {
-		p.Payload = b[p.Header.len:n]
+	p.raw = b
+	if p.Header, err = ParseHeader(b); err == nil {
+		if flags&msgTrunc != 0 {
+			return p, &TruncatedError{BufferSize: bufSize}
+		}
+		p.Payload = b[p.Header.len:]
 	}
-	return
+	return p, err
+}
+
+// parseTruncated parses what could be read of a datagram larger than the bufSize bytes buffer, for platforms where
+// such reads fail instead of setting msgTrunc
+func parseTruncated(p *Packet, b, oob []byte, src *net.UDPAddr, bufSize int) (*Packet, error) {
+	p.Received = parseReceiveInfo(oob, src)
+	p.raw = b
+	p.Header, _ = ParseHeader(b)
+	return p, &TruncatedError{BufferSize: bufSize}
 }
 
 // SDPConn implements ReadCloser for SDP/SAP packets
@@ -98,91 +182,503 @@ func (c *SDPConn) Read() (*SDPPacket, error) {
 
 // AdvLifetime is a sdp.Description annotated with timing information
 type AdvLifetime struct {
+	// Session is the description of application/sdp sessions, it is empty for other payload types
 	sdp.Session
-	Hash     uint16
+	// PayloadType is the MIME type of the description
+	PayloadType string
+	// Description is the decoded description of the session, whatever its payload type
+	Description Description
+	// Encrypted is set when the payload of the session is encrypted and its key is unknown
+	Encrypted bool
+	Hash      uint16
+	// Key identifies the session in the accumulator, see StreamsAccumulator.Lookup
+	Key      SessionKey
 	Last     time.Time
 	Interval time.Duration
 	Count    int
+	// Deleted is set when the announcer explicitly withdrew the session with a deletion packet
+	Deleted   bool
+	DeletedAt time.Time
+	// Expired is set when the session timed out according to the accumulator ExpiryPolicy
+	Expired   bool
+	ExpiredAt time.Time
+	// Replaced is set when the session was announced again under another message ID hash
+	Replaced   bool
+	ReplacedAt time.Time
+	// Replaces is the key of the previous version of the session, when it was announced under another hash
+	Replaces SessionKey
+	// Changes is the difference with the previous description of the session, under the same or a previous hash. It
+	// is cleared by the next announcement without change
+	Changes SessionDiff
+	// SilentChanges counts the description changes which kept the same message ID hash, against RFC2974
+	SilentChanges int
+	// SpuriousRehash is set when the session was announced under a new hash without any change in its description. It
+	// is cleared by the next announcement
+	SpuriousRehash bool
+	// Auth is the verification status of the latest announcement of the session
+	Auth AuthStatus
+	// Unauthenticated counts the announcements ignored because they were not verified while the session is
+	Unauthenticated int
+	// Received is the reception metadata of the latest announcement of the session
+	Received ReceiveInfo
+	// SAPVersion is the SAP version of the latest announcement of the session
+	SAPVersion uint8
+	// VersionCounts is the number of announcements of the session received with each SAP version
+	VersionCounts [2]int
+	// Timeout is the explicit timeout of encrypted SAPv0 sessions, zero otherwise
+	Timeout time.Time
+	// Sources are the L3 senders of the announcements of the session, the latest one last
+	Sources []SourceSeen
+	// Anomalies are the inconsistencies detected between Sources and the OrigSrc of the session
+	Anomalies SourceAnomaly
+	// First is the time of the first announcement of the session
+	First time.Time
+	// Interfaces are the interfaces the session was received on
+	Interfaces []InterfaceSeen
+	// MissingOn are the interfaces on which other sessions are received, but not this one recently
+	MissingOn []string
+	// Scope is the scope of the SAP group the latest announcement was received on, see Received.Dst
+	Scope string
+	// ConnectionScope is the scope of the connection address of the session
+	ConnectionScope string
+	// MisScoped is set when the session was announced in another scope than the one of its connection address
+	MisScoped bool
+	// Lints are the problems found in the description of SDP sessions, updated on each announcement
+	Lints []Lint
 }
 
-type origHash struct {
+// SessionKey identifies the announcements of a session in an accumulator, by message ID hash and originating source
+type SessionKey struct {
 	IDHash  uint16
 	OrigSrc [16]byte
 }
 
+// NewSessionKey returns the key of the announcements with the given message ID hash and originating source
+func NewSessionKey(idHash uint16, origSrc net.IP) SessionKey {
+	key := SessionKey{IDHash: idHash}
+	copy(key.OrigSrc[:], origSrc.To16())
+	return key
+}
+
 type channelMap struct {
 	sync.RWMutex
-	conn          *SDPConn
-	lifetimes     map[origHash]AdvLifetime
-	notifications chan bool
+	conns     []*SDPConn
+	lifetimes map[SessionKey]AdvLifetime
+	// origins and names index lifetimes by SDP origin and session name, see store
+	origins    sessionIndex
+	names      sessionIndex
+	policy     ExpiryPolicy
+	verifiers  Verifiers
+	decryption *Decryption
+	errors     map[ErrorKey]int
+	// interfaces are the interfaces announcements were received on, ifnames caches their names
+	interfaces []InterfaceSeen
+	ifnames    map[int]string
+
+	subscriptions
+	done    chan struct{}
+	sweeper sync.WaitGroup
+	// finished is closed once the accumulator is stopped, err is then the error which stopped it
+	finished chan struct{}
+	err      error
 }
 
 // StreamsAccumulator receives and counts SAP announcements and provides a list of them in a channel when needed
 type StreamsAccumulator interface {
-	// Iterator makes it possible to use for := range loops on this interface
+	// Iterator makes it possible to use for := range loops on this interface. It iterates over a snapshot, the loop
+	// may be broken early
 	Iterator(ChannelFilter) <-chan AdvLifetime
-	// WaitChange provides notification when an item is modified
+	// Snapshot returns a copy of the sessions matching the filter
+	Snapshot(ChannelFilter) Sessions
+	// Lookup returns the session with the given key
+	Lookup(SessionKey) (AdvLifetime, bool)
+	// WaitChange provides notification when an item is modified. Notifications are coalesced when the consumer is
+	// not waiting. It is a shorthand for the Wait method of a shared default subscription
 	WaitChange() bool
+	// Events returns a channel of typed session changes, closed when the accumulator stops. It is a shorthand for
+	// the Events method of a shared default subscription, events are dropped when it is not drained
+	Events() <-chan Event
+	// Subscribe returns an independent subscription to the changes of the accumulator
+	Subscribe() *Subscription
+	// SetExpiryPolicy changes when sessions expire and are evicted. Invalid policies are rejected, see
+	// ExpiryPolicy.Validate
+	SetExpiryPolicy(ExpiryPolicy) error
+	// SetVerifiers enables the verification of the authentication data of received packets
+	SetVerifiers(Verifiers)
+	// SetDecryption enables the decryption of the encrypted packets received. Sessions whose key is unknown are
+	// still recorded as encrypted
+	SetDecryption(*Decryption)
+	// ErrorCounts returns the number of malformed packets received per sender and kind of error
+	ErrorCounts() map[ErrorKey]int
+	// Interfaces returns the interfaces announcements were received on
+	Interfaces() []InterfaceSeen
 	// Close cleans up resources after use
 	Close()
+	// Done returns a channel closed once the accumulator is stopped, after Close or a read error
+	Done() <-chan struct{}
+	// Err returns the error which stopped the accumulator once Done is closed, nil before
+	Err() error
 }
 
 func (m *channelMap) Iterator(filter ChannelFilter) <-chan AdvLifetime {
+	snapshot := m.Snapshot(filter)
+	ch := make(chan AdvLifetime, len(snapshot))
+	for _, lf := range snapshot {
+		ch <- lf
+	}
+	close(ch)
+	return ch
+}
+
+func (m *channelMap) Snapshot(filter ChannelFilter) Sessions {
 	m.RLock()
-	ch := make(chan AdvLifetime, len(m.lifetimes))
-	go func() {
-		for _, v := range m.lifetimes {
-			if filter(&v) {
-				ch <- v
-			}
+	defer m.RUnlock()
+	snapshot := make(Sessions, 0, len(m.lifetimes))
+	for _, lf := range m.lifetimes {
+		// AdvLifetime values are never modified in place, copies are safe to share
+		if filter == nil || filter(&lf) {
+			snapshot = append(snapshot, lf)
 		}
-		m.RUnlock()
-		close(ch)
-	}()
-	return ch
+	}
+	return snapshot
+}
+
+func (m *channelMap) Lookup(key SessionKey) (AdvLifetime, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	lf, ok := m.lifetimes[key]
+	return lf, ok
 }
 
 func (m *channelMap) WaitChange() bool {
-	_, ok := <-m.notifications
-	return ok
+	return m.defaultSubscription().Wait()
+}
+
+func (m *channelMap) Events() <-chan Event {
+	return m.defaultSubscription().Events()
+}
+
+func (m *channelMap) Subscribe() *Subscription {
+	return m.subscribe()
+}
+
+func (m *channelMap) SetExpiryPolicy(policy ExpiryPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.policy = policy
+	return nil
+}
+
+func (m *channelMap) SetVerifiers(v Verifiers) {
+	m.Lock()
+	defer m.Unlock()
+	m.verifiers = v
+}
+
+func (m *channelMap) SetDecryption(d *Decryption) {
+	m.Lock()
+	defer m.Unlock()
+	m.decryption = d
+}
+
+func (m *channelMap) ErrorCounts() map[ErrorKey]int {
+	m.RLock()
+	defer m.RUnlock()
+	counts := make(map[ErrorKey]int, len(m.errors))
+	for k, v := range m.errors {
+		counts[k] = v
+	}
+	return counts
+}
+
+func (m *channelMap) countError(p *Packet, err error) {
+	key := ErrorKey{Kind: KindOf(err)}
+	if p.Received.Src != nil {
+		key.Src = p.Received.Src.String()
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.errors[key]++
 }
 
 func (m *channelMap) Close() {
-	m.conn.Close()
+	for _, c := range m.conns {
+		c.Close()
+	}
+}
+
+// emit notifies the subscribers of an event, without blocking
+func (m *channelMap) emit(ev Event) {
+	m.broadcast(&ev)
+}
+
+// notify notifies the subscribers of a change without event, without blocking
+func (m *channelMap) notify() {
+	m.broadcast(nil)
+}
+
+func (m *channelMap) Done() <-chan struct{} {
+	return m.finished
+}
+
+func (m *channelMap) Err() error {
+	select {
+	case <-m.finished:
+		return m.err
+	default:
+		return nil
+	}
+}
+
+// stop stops the expiry of sessions and the subscriptions, and records the error which stopped the accumulator
+func (m *channelMap) stop(err error) {
+	close(m.done)
+	m.sweeper.Wait()
+	m.subscriptions.stop()
+	m.err = err
+	close(m.finished)
+}
+
+func newChannelMap(conns ...*SDPConn) *channelMap {
+	channels := &channelMap{
+		conns:     conns,
+		lifetimes: make(map[SessionKey]AdvLifetime),
+		origins:   make(sessionIndex),
+		names:     make(sessionIndex),
+		errors:    make(map[ErrorKey]int),
+		policy:    DefaultExpiryPolicy,
+		done:      make(chan struct{}),
+		finished:  make(chan struct{}),
+	}
+	channels.sweeper.Add(1)
+	go expireStreams(channels)
+	return channels
 }
 
 // CountStreams starts a routine that keeps count of available streams
 func (c *SDPConn) CountStreams() StreamsAccumulator {
-	channels := channelMap{
-		conn:          c,
-		lifetimes:     make(map[origHash]AdvLifetime),
-		notifications: make(chan bool),
-	}
-	go countStreams(&channels)
-	return &channels
+	channels := newChannelMap(c)
+	go func() {
+		channels.stop(countStreams(channels, c))
+	}()
+	return channels
 }
 
-func countStreams(channels *channelMap) {
+// CountStreamsContext is like CountStreams, but stops the accumulator when ctx is done: the given groups, joined
+// with mcastutil.ListenMulticastUDP, are left and the connection is closed before Done is closed, and Err returns
+// ctx.Err()
+func (c *SDPConn) CountStreamsContext(ctx context.Context, groups []net.IP, ifi *net.Interface) StreamsAccumulator {
+	channels := newChannelMap(c)
+	go func() {
+		conn := (*net.UDPConn)(c)
+		stopWatching := mcastutil.InterruptOnDone(ctx, conn)
+		err := countStreams(channels, c)
+		stopWatching()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			// Errors are ignored as the connection is closed anyway, which also leaves the groups
+			_ = mcastutil.LeaveGroups(conn, groups, ifi)
+			channels.Close()
+		}
+		channels.stop(err)
+	}()
+	return channels
+}
+
+// countStreams records the packets read from a connection until a read fails, and returns the read error
+func countStreams(channels *channelMap, c *SDPConn) error {
+	reader := (*Conn)(c).NewBatchReader(0, 0)
 	for {
-		p, err := channels.conn.Read()
-		if err != nil {
-			break
+		p, err := reader.Read()
+		if p == nil {
+			return err
 		}
+		channels.count(p, err)
+	}
+}
 
-		hash := origHash{IDHash: p.IDHash}
-		copy(hash.OrigSrc[:], p.OrigSrc.To16())
-		channels.Lock()
-		if channel, ok := channels.lifetimes[hash]; ok {
-			channel.Interval = time.Now().Sub(channel.Last)
-			channel.Last = time.Now()
-			channel.Count++
-			channels.lifetimes[hash] = channel
+// count records a received packet. The packet buffer is reused afterwards, nothing aliasing it may be kept
+func (m *channelMap) count(p *Packet, err error) {
+	if err != nil {
+		m.countError(p, err)
+		return
+	}
+
+	m.RLock()
+	verifiers, decryption := m.verifiers, m.decryption
+	m.RUnlock()
+	if err = decryption.decrypt(p); err != nil {
+		m.countError(p, err)
+		return
+	}
+	if verifiers != nil {
+		p.Verify(verifiers)
+	}
+
+	hash := NewSessionKey(p.IDHash, p.OrigSrc)
+	if p.Type == TypeDelete {
+		// Deletion payloads may only contain the origin line, they are not parsed
+		if ev, ok := m.delete(hash, p.Auth); ok {
+			m.emit(ev)
+		}
+		return
+	}
+	decoded, err := p.Decode()
+	if err != nil {
+		m.countError(p, err)
+		return
+	}
+	ev := m.refresh(hash, decoded)
+	if ev.Type == SessionAdded && ev.After.Replaces != (SessionKey{}) {
+		if replaced, ok := m.replace(ev.After.Replaces); ok {
+			m.emit(replaced)
+		}
+	}
+	m.emit(ev)
+}
+
+// refresh records an announcement of a session
+func (m *channelMap) refresh(hash SessionKey, p *DecodedPacket) Event {
+	now := time.Now()
+	var session sdp.Session
+	if desc, ok := p.Payload.(*SDPDescription); ok {
+		session = desc.Session
+	}
+	_, encrypted := p.Payload.(*EncryptedPayload)
+	m.Lock()
+	defer m.Unlock()
+	before, ok := m.lifetimes[hash]
+	ev := Event{Before: before}
+	if ok && before.live() && before.Auth == AuthVerified && p.Auth != AuthVerified {
+		// Anyone can spoof the source of an unauthenticated packet, it must not replace a verified session
+		ev.Type = SessionRefreshed
+		ev.After = before
+		ev.After.Unauthenticated++
+		// The sender is still recorded, so that spoofers show up in the anomalies of the session
+		ev.After.trackSource(p.Received.Src, p.OrigSrc, now)
+		m.store(hash, ev.After)
+		return ev
+	}
+	if ok && before.live() {
+		ev.After = before
+		ev.After.Interval = now.Sub(before.Last)
+		ev.After.Last = now
+		ev.After.Count++
+		ev.After.Auth = p.Auth
+		ev.After.Received = p.Received
+		ev.After.Encrypted = encrypted
+		if changes := DiffSessions(&before.Session, &session); len(changes) > 0 ||
+			p.PayloadType != before.PayloadType || describe(p.Payload) != describe(before.Description) {
+			// RFC2974 requires a new message ID hash for each change of the description
+			ev.Type = SessionModified
+			ev.After.Session = session
+			ev.After.PayloadType = p.PayloadType
+			ev.After.Description = p.Payload
+			ev.After.Changes = changes
+			ev.After.SilentChanges++
+			ev.After.SpuriousRehash = false
 		} else {
-			channels.lifetimes[hash] = AdvLifetime{Session: p.Payload, Hash: p.IDHash, Last: time.Now(), Count: 1}
+			ev.Type = SessionRefreshed
+			ev.After.Changes = nil
+			ev.After.SpuriousRehash = false
+		}
+	} else {
+		ev.Type = SessionAdded
+		ev.After = AdvLifetime{
+			Session:     session,
+			PayloadType: p.PayloadType,
+			Description: p.Payload,
+			Encrypted:   encrypted,
+			Hash:        p.IDHash,
+			First:       now,
+			Last:        now,
+			Count:       1,
+			Auth:        p.Auth,
+			Received:    p.Received,
+		}
+		if previous, ok := m.previousVersion(hash, &session, p.Auth); ok {
+			ev.After.Replaces = previous.Key
+			ev.After.Changes = DiffSessions(&previous.Session, &session)
+			ev.After.SpuriousRehash = len(ev.After.Changes) == 0 &&
+				describe(previous.Description) == describe(p.Payload)
 		}
-		channels.Unlock()
-		channels.notifications <- true
 	}
+	ev.After.Key = hash
+	ev.After.SAPVersion = p.Version
+	if int(p.Version) < len(ev.After.VersionCounts) {
+		ev.After.VersionCounts[p.Version]++
+	}
+	ev.After.Timeout = p.TimeoutTime()
+	ev.After.trackScope(p.Received.Dst)
+	ev.After.Lints = m.lint(hash, &ev.After)
+	ev.After.trackSource(p.Received.Src, p.OrigSrc, now)
+	m.trackInterface(&ev.After, m.interfaceName(p.Received.IfIndex), now)
+	m.store(hash, ev.After)
+	m.relintNames(hash, before.Name, ev.After.Name)
+	return ev
+}
 
-	close(channels.notifications)
+// previousVersion finds the latest live announcement of the same session from the same source under another message
+// ID hash. Verified announcements are only replaced by verified ones
+func (m *channelMap) previousVersion(hash SessionKey, s *sdp.Session, auth AuthStatus) (AdvLifetime, bool) {
+	key, err := sessionKey(s)
+	if err != nil {
+		return AdvLifetime{}, false
+	}
+	var previous AdvLifetime
+	var found bool
+	for h := range m.origins[key] {
+		channel := m.lifetimes[h]
+		if h == hash || h.OrigSrc != hash.OrigSrc || !channel.live() ||
+			(channel.Auth == AuthVerified && auth != AuthVerified) {
+			continue
+		}
+		if !found || channel.Last.After(previous.Last) {
+			previous, found = channel, true
+		}
+	}
+	return previous, found
+}
+
+// replace marks the previous version of a session announced under a new hash as replaced
+func (m *channelMap) replace(hash SessionKey) (Event, bool) {
+	m.Lock()
+	defer m.Unlock()
+	channel, ok := m.lifetimes[hash]
+	if !ok || !channel.live() {
+		return Event{}, false
+	}
+	ev := Event{Type: SessionReplaced, Before: channel}
+	channel.Replaced = true
+	channel.ReplacedAt = time.Now()
+	m.lifetimes[hash] = channel
+	m.relintNames(hash, channel.Name)
+	ev.After = channel
+	return ev, true
+}
+
+// live reports whether a session is neither deleted, expired nor replaced
+func (lf *AdvLifetime) live() bool {
+	return !lf.Deleted && !lf.Expired && !lf.Replaced
+}
+
+// delete marks a known session as deleted. Sessions authenticated by a trusted key can only be deleted by an
+// authenticated deletion
+func (m *channelMap) delete(hash SessionKey, auth AuthStatus) (Event, bool) {
+	m.Lock()
+	defer m.Unlock()
+	channel, ok := m.lifetimes[hash]
+	if !ok || channel.Deleted || (channel.Auth == AuthVerified && auth != AuthVerified) {
+		return Event{}, false
+	}
+	ev := Event{Type: SessionDeleted, Before: channel}
+	channel.Deleted = true
+	channel.DeletedAt = time.Now()
+	m.lifetimes[hash] = channel
+	m.relintNames(hash, channel.Name)
+	ev.After = channel
+	return ev, true
 }
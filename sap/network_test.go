@@ -0,0 +1,326 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Natolumin/multidrop/mcastutil"
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+func TestDelete(t *testing.T) {
+	known := SessionKey{IDHash: 0xf830}
+	channels := channelMap{
+		lifetimes: map[SessionKey]AdvLifetime{
+			known: {Hash: known.IDHash, Last: time.Now(), Count: 1},
+		},
+	}
+	if _, ok := channels.delete(SessionKey{IDHash: 0x1234}, AuthUnverified); ok {
+		t.Errorf("Unknown session deleted")
+	}
+	ev, ok := channels.delete(known, AuthUnverified)
+	if !ok {
+		t.Fatalf("Known session not deleted")
+	}
+	if ev.Type != SessionDeleted || ev.Before.Deleted || !ev.After.Deleted {
+		t.Errorf("Invalid deletion event: %+v", ev)
+	}
+	if _, ok := channels.delete(known, AuthUnverified); ok {
+		t.Errorf("Session deleted twice")
+	}
+	lf := channels.lifetimes[known]
+	if !lf.Deleted || FilterNotExpired(&lf) || !FilterDeleted(&lf) {
+		t.Errorf("Deleted session still reported as valid: %+v", lf)
+	}
+}
+
+func TestRefreshEvents(t *testing.T) {
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime)}
+	hash := SessionKey{IDHash: 0xf830}
+	p := &DecodedPacket{Header: Header{IDHash: hash.IDHash}, Payload: &SDPDescription{}}
+	p.Payload.(*SDPDescription).Name = "test"
+
+	expected := []EventType{SessionAdded, SessionRefreshed, SessionModified}
+	for i, typ := range expected {
+		if typ == SessionModified {
+			p.Payload = &SDPDescription{Session: sdp.Session{Name: "renamed"}}
+		}
+		ev := channels.refresh(hash, p)
+		if ev.Type != typ {
+			t.Errorf("%d: Expected %v event, got %v", i+1, typ, ev.Type)
+		}
+		if ev.After.Count != i+1 || ev.Before.Count != i {
+			t.Errorf("%d: Invalid counts before and after: %d, %d", i+1, ev.Before.Count, ev.After.Count)
+		}
+	}
+	if lf := channels.lifetimes[hash]; lf.Name != "renamed" {
+		t.Errorf("Modified session not stored: %s", lf.Name)
+	} else if lf.VersionCounts != [2]int{len(expected), 0} {
+		t.Errorf("Invalid SAP version counts: %v", lf.VersionCounts)
+	}
+
+	channels.delete(hash, AuthUnverified)
+	if ev := channels.refresh(hash, p); ev.Type != SessionAdded || !ev.Before.Deleted {
+		t.Errorf("Deleted session coming back not reported as added: %+v", ev)
+	}
+}
+
+func TestReadReceiveInfo(t *testing.T) {
+	lconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("Could not listen on loopback: %v", err)
+	}
+	defer lconn.Close()
+	mcastutil.EnableControlMessages(lconn)
+
+	sender, err := net.DialUDP("udp4", nil, lconn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Could not create sender: %v", err)
+	}
+	defer sender.Close()
+	p := Packet{Header: Header{Version: 1, IDHash: 0xf830, OrigSrc: net.IP{192, 0, 2, 1}, PayloadType: SDPPayloadType}}
+	b := make([]byte, p.Length())
+	if _, err = p.WriteBinary(b); err != nil {
+		t.Fatalf("Could not write packet: %v", err)
+	}
+	if _, err = sender.Write(b); err != nil {
+		t.Fatalf("Could not send packet: %v", err)
+	}
+
+	received, err := (*Conn)(lconn).Read()
+	if err != nil {
+		t.Fatalf("Could not read packet: %v", err)
+	}
+	ri := received.Received
+	if !ri.Src.Equal(net.IPv4(127, 0, 0, 1)) || !ri.Dst.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Invalid source or destination: %+v", ri)
+	}
+	if ri.IfIndex == 0 || ri.HopLimit <= 0 {
+		t.Errorf("Missing interface or TTL: %+v", ri)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	lconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("Could not listen on loopback: %v", err)
+	}
+	defer lconn.Close()
+	sender, err := net.DialUDP("udp4", nil, lconn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Could not create sender: %v", err)
+	}
+	defer sender.Close()
+
+	p := Packet{
+		Header:  Header{Version: 1, IDHash: 0xf830, OrigSrc: net.IP{192, 0, 2, 1}, PayloadType: SDPPayloadType},
+		Payload: make([]byte, 4000),
+	}
+	b := make([]byte, p.Length())
+	if _, err = p.WriteBinary(b); err != nil {
+		t.Fatalf("Could not write packet: %v", err)
+	}
+
+	for _, size := range []int{1500, MaxDatagramSize} {
+		if _, err = sender.Write(b); err != nil {
+			t.Fatalf("Could not send packet: %v", err)
+		}
+		received, err := (*Conn)(lconn).ReadSize(size)
+		if _, truncated := err.(*TruncatedError); truncated != (size < len(b)) {
+			t.Errorf("%d bytes buffer: unexpected error %v", size, err)
+		}
+		if received == nil || received.IDHash != p.IDHash {
+			t.Errorf("%d bytes buffer: header not parsed", size)
+		}
+	}
+}
+
+func TestBatchReader(t *testing.T) {
+	conn, sender := loopbackPair(t)
+	defer (*net.UDPConn)(conn).Close()
+	defer sender.Close()
+
+	const bufSize = 1500
+	reader := conn.NewBatchReader(2, bufSize)
+
+	sizes := []int{10, 20, 3000, 30}
+	for i, size := range sizes {
+		p := Packet{
+			Header:  Header{Version: 1, IDHash: uint16(i + 1), OrigSrc: net.IP{192, 0, 2, 1}, PayloadType: SDPPayloadType},
+			Payload: make([]byte, size),
+		}
+		b := make([]byte, p.Length())
+		n, err := p.WriteBinary(b)
+		if err != nil {
+			t.Fatalf("Could not write packet: %v", err)
+		}
+		if _, err = sender.Write(b[:n]); err != nil {
+			t.Fatalf("Could not send packet: %v", err)
+		}
+	}
+	for i, size := range sizes {
+		p, err := reader.Read()
+		if p == nil {
+			t.Fatalf("%d: Could not read packet: %v", i+1, err)
+		}
+		if _, truncated := err.(*TruncatedError); truncated != (size > bufSize) {
+			t.Errorf("%d: Unexpected error %v", i+1, err)
+		} else if p.IDHash != uint16(i+1) || (!truncated && len(p.Payload) != size) {
+			t.Errorf("%d: Invalid packet %+v", i+1, p.Header)
+		}
+		if p.Received.Src == nil {
+			t.Errorf("%d: Missing source address", i+1)
+		}
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime), done: make(chan struct{}),
+		finished: make(chan struct{})}
+	first, second := channels.Subscribe(), channels.Subscribe()
+
+	const sent = eventsBuffer + 10
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < sent; i++ {
+			channels.emit(Event{Type: SessionRefreshed})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Accumulator blocked by subscribers not receiving")
+	}
+
+	for i, sub := range []*Subscription{first, second} {
+		if len(sub.Events()) != eventsBuffer || sub.Dropped() != sent-eventsBuffer {
+			t.Errorf("%d: Expected %d buffered and %d dropped events, got %d and %d", i+1, eventsBuffer,
+				sent-eventsBuffer, len(sub.Events()), sub.Dropped())
+		}
+		if len(sub.Changes()) != 1 {
+			t.Errorf("%d: Change notifications not coalesced", i+1)
+		}
+	}
+
+	first.Close()
+	first.Close()
+	if _, ok := <-first.Changes(); !ok {
+		t.Errorf("Pending notification lost on close")
+	} else if first.Wait() {
+		t.Errorf("Closed subscription still notified")
+	}
+	channels.notify()
+	if len(second.Changes()) != 1 {
+		t.Errorf("Remaining subscription not notified")
+	}
+
+	channels.stop(nil)
+	for range second.Events() {
+	}
+	second.Wait() // Pending notification
+	if second.Wait() {
+		t.Errorf("Subscription not closed when the accumulator stops")
+	}
+	if channels.WaitChange() {
+		t.Errorf("Subscription created after stop not closed")
+	}
+}
+
+func TestIteratorEarlyBreak(t *testing.T) {
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime)}
+	for i := 1; i <= 10; i++ {
+		channels.refresh(SessionKey{IDHash: uint16(i)}, &DecodedPacket{Header: Header{IDHash: uint16(i)},
+			Payload: &SDPDescription{Session: sdp.Session{Name: string(rune('a' + 10 - i))}}})
+	}
+	for range channels.Iterator(FilterNotExpired) {
+		break
+	}
+
+	done := make(chan struct{})
+	go func() {
+		channels.refresh(SessionKey{IDHash: 42}, &DecodedPacket{Header: Header{IDHash: 42}, Payload: &SDPDescription{}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Accumulator locked after breaking out of the iterator")
+	}
+
+	snapshot := channels.Snapshot(FilterNotExpired)
+	if len(snapshot) != 11 {
+		t.Fatalf("Expected 11 sessions in snapshot, got %d", len(snapshot))
+	}
+	snapshot.Sort(ByName)
+	for i := 1; i < len(snapshot); i++ {
+		if snapshot[i-1].Name > snapshot[i].Name {
+			t.Errorf("Snapshot not sorted by name: %q before %q", snapshot[i-1].Name, snapshot[i].Name)
+		}
+	}
+	key := SessionKey{IDHash: 3}
+	if lf, ok := channels.Lookup(key); !ok || lf.Hash != 3 || lf.Key != key {
+		t.Errorf("Could not lookup session by key: %+v", lf)
+	}
+	if lf, ok := snapshot.Find(key); !ok || lf.Hash != 3 {
+		t.Errorf("Could not find session by key in snapshot: %+v", lf)
+	}
+}
+
+func TestContextTeardown(t *testing.T) {
+	conn, sender := loopbackPair(t)
+	defer sender.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if p, err := conn.ReadContext(ctx); p != nil || err != context.Canceled {
+		t.Errorf("Read not interrupted by cancellation: %v", err)
+	}
+	if _, err := sender.Write([]byte{0x20, 0, 0, 1, 192, 0, 2, 1}); err != nil {
+		t.Fatalf("Could not send packet: %v", err)
+	}
+	if p, err := conn.ReadContext(context.Background()); p == nil || p.IDHash != 1 {
+		t.Errorf("Could not read after cancellation: %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	streams := (*SDPConn)(conn).CountStreamsContext(ctx, nil, nil)
+	if streams.Err() != nil {
+		t.Errorf("Running accumulator reports an error")
+	}
+	cancel()
+	select {
+	case <-streams.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Accumulator not stopped by cancellation")
+	}
+	if streams.Err() != context.Canceled {
+		t.Errorf("Expected cancellation error, got %v", streams.Err())
+	}
+	// The deadline only bounds the read if the connection was left open
+	(*net.UDPConn)(conn).SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := (*net.UDPConn)(conn).Read(make([]byte, 1)); err == nil ||
+		!strings.Contains(err.Error(), "use of closed network connection") {
+		t.Errorf("Connection not closed on teardown: %v", err)
+	}
+}
@@ -17,10 +17,13 @@ package sap
 
 import (
 	"bytes"
+	"compress/zlib"
 	"encoding/binary"
 	"errors"
-	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"time"
 
 	"github.com/pixelbender/go-sdp/sdp"
 )
@@ -37,9 +40,16 @@ type Header struct {
 	IDHash      uint16
 	AuthData    *AuthData
 	OrigSrc     net.IP
+	// Timeout is the NTP time in seconds at which an encrypted SAPv0 session times out. It is only present in
+	// encrypted SAPv0 packets, SAPv1 removed it
+	Timeout     uint32
 	PayloadType string
 	// Additional "metadata" fields
 	len int
+	// Auth is the result of the verification of AuthData, see Packet.Verify
+	Auth AuthStatus
+	// Decrypted is set once the payload of an encrypted packet is decrypted, see Packet.Decrypt
+	Decrypted bool
 }
 
 // AuthData is the subheader containing authentication data
@@ -62,12 +72,20 @@ const (
 type Packet struct {
 	Header
 	Payload []byte
+	// Received is the reception metadata of the packet, when read from a Conn
+	Received ReceiveInfo
+	// raw is the packet as received, if it was
+	raw []byte
+	// pool is the pooled buffer the packet was read into, see Conn.ReadPooled
+	pool *readBuffer
 }
 
 // SDPPacket is an SAP packet with decoded SDP payload
 type SDPPacket struct {
 	Header
 	Payload sdp.Session
+	// Received is the reception metadata of the packet, when read from a Conn
+	Received ReceiveInfo
 }
 
 const (
@@ -84,37 +102,37 @@ const (
 	SDPPayloadType = "application/sdp"
 )
 
+var sapv0PayloadType = []byte(SDPPayloadType + "\x00")
+
 // ParseHeader parses the given buffer for an SAP header
 func ParseHeader(b []byte) (Header, error) {
 	if len(b) < 4 {
-		err := errors.New("invalid header length")
-		return Header{}, err
+		return Header{}, &ParseError{Kind: KindLength, Field: "header", Offset: len(b), Packet: copyBytes(b)}
 	}
 	header := Header{
 		Version:     (b[0] & 0xe0) >> 5,
 		AddressType: (b[0] & 0x10) != 0,
 		Reserved:    (b[0] & 0x08) != 0,
 		Type:        (b[0] & 0x04) != 0,
-		Compressed:  (b[0] & 0x02) != 0,
-		Encrypted:   (b[0] & 0x01) != 0,
+		Encrypted:   (b[0] & 0x02) != 0,
+		Compressed:  (b[0] & 0x01) != 0,
 		AuthLen:     b[1],
 		IDHash:      uint16(b[3]) | uint16(b[2])<<8,
 		len:         4,
 	}
 	// Sanity checks
 	if header.Version > 1 {
-		err := errors.New("invalid SAP version")
-		return header, err
+		return header, &ParseError{Kind: KindVersion, Field: "version", Offset: 0, Packet: copyBytes(b)}
 	}
 	if header.AddressType == AddrTypeV4 {
 		if len(b) < header.len+net.IPv4len {
-			return header, errors.New("invalid header length")
+			return header, &ParseError{Kind: KindLength, Field: "originating source", Offset: header.len, Packet: copyBytes(b)}
 		}
 		header.OrigSrc = b[4:8]
 		header.len += 4
 	} else {
 		if len(b) < header.len+net.IPv6len {
-			return header, errors.New("invalid header length")
+			return header, &ParseError{Kind: KindLength, Field: "originating source", Offset: header.len, Packet: copyBytes(b)}
 		}
 		header.OrigSrc = b[4:20]
 		header.len += 16
@@ -122,16 +140,27 @@ func ParseHeader(b []byte) (Header, error) {
 
 	if header.AuthLen > 0 {
 		if len(b) < header.len+int(header.AuthLen)*4 {
-			return header, errors.New("invalid header length")
+			return header, &ParseError{Kind: KindLength, Field: "authentication data", Offset: header.len, Packet: copyBytes(b)}
 		}
 		ahData, err := parseAuthData(b[header.len : header.len+(int)(header.AuthLen)*4])
 		if err != nil {
+			// Offsets of parseAuthData are relative to the authentication data
+			err.Offset += header.len
+			err.Packet = copyBytes(b)
 			return header, err
 		}
 		header.len += (int)(header.AuthLen) * 4
 		header.AuthData = &ahData
 	}
 
+	if header.Version == 0 && header.Encrypted {
+		if len(b) < header.len+4 {
+			return header, &ParseError{Kind: KindLength, Field: "timeout", Offset: header.len, Packet: copyBytes(b)}
+		}
+		header.Timeout = binary.BigEndian.Uint32(b[header.len : header.len+4])
+		header.len += 4
+	}
+
 	if header.Version != 0 {
 		// Special case for no payload field, implicit "application/sdp"
 		if len(b) >= header.len+3 && bytes.Equal(b[header.len:header.len+3], []byte{'v', '=', '0'}) {
@@ -139,31 +168,47 @@ func ParseHeader(b []byte) (Header, error) {
 		} else {
 			pltypelen := bytes.Index(b[header.len:], []byte{0})
 			if pltypelen < 0 {
-				return header, errors.New("malformed payload type")
+				return header, &ParseError{Kind: KindPayloadType, Field: "payload type", Offset: header.len, Packet: copyBytes(b)}
 			}
 			header.PayloadType = string(b[header.len : header.len+pltypelen])
 			header.len += pltypelen + 1 // nullbyte at the end of payloadtype
 		}
 	} else {
+		// SAPv0 has no payload type, but some announcers include the SAPv1 one anyway
 		header.PayloadType = SDPPayloadType
+		if bytes.HasPrefix(b[header.len:], sapv0PayloadType) {
+			header.len += len(sapv0PayloadType)
+		}
 	}
 
 	return header, nil
 }
 
-func parseAuthData(b []byte) (AuthData, error) {
+// ntpEpochOffset is the number of seconds between the NTP and Unix epochs
+const ntpEpochOffset = 2208988800
+
+// TimeoutTime returns the time at which an encrypted SAPv0 session times out, or the zero time if the packet has no
+// timeout
+func (h *Header) TimeoutTime() time.Time {
+	if h.Timeout == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(h.Timeout)-ntpEpochOffset, 0)
+}
+
+func parseAuthData(b []byte) (AuthData, *ParseError) {
 	d := AuthData{
 		Version:    (b[0] & 0xe0) >> 5,
 		Padding:    (b[0] & 0x10) != 0,
 		AuthMethod: b[0] & 0x0f,
 	}
 	if d.Version != 1 {
-		return d, fmt.Errorf("version %d is not supported", d.Version)
+		return d, &ParseError{Kind: KindAuthVersion, Field: "authentication version", Offset: 0}
 	}
 	if d.Padding {
 		d.PaddingLen = b[len(b)-1]
 		if int(d.PaddingLen) > len(b)-1 {
-			return d, errors.New("invalid padding length")
+			return d, &ParseError{Kind: KindAuthPadding, Field: "padding length", Offset: len(b) - 1}
 		}
 	}
 
@@ -180,7 +225,9 @@ func booluint8(b bool) uint8 {
 
 //WriteBinary writes a packet in binary format
 func (p *Packet) WriteBinary(b []byte) (int, error) {
-	p.recomputeLen()
+	if err := p.recomputeLen(); err != nil {
+		return 0, err
+	}
 
 	if len(b) < p.len+len([]byte(p.Payload)) {
 		return 0, errors.New("buffer too small")
@@ -203,6 +250,10 @@ func (p *Packet) WriteBinary(b []byte) (int, error) {
 		}
 		curlen += int(p.AuthLen) * 4
 	}
+	if p.Version == 0 && p.Encrypted {
+		binary.BigEndian.PutUint32(b[curlen:curlen+4], p.Timeout)
+		curlen += 4
+	}
 	if p.Version == 1 {
 		copy(b[curlen:curlen+len([]byte(p.PayloadType))], []byte(p.PayloadType))
 		curlen += len([]byte(p.PayloadType))
@@ -214,12 +265,20 @@ func (p *Packet) WriteBinary(b []byte) (int, error) {
 	return curlen, nil
 }
 
-//WriteBinary writes out a packet in binary format
+//WriteBinary writes out a packet in binary format. The payload is compressed if the Compressed flag is set
 func (p *SDPPacket) WriteBinary(b []byte) (int, error) {
-	packet := Packet{Header: p.Header, Payload: []byte(p.Payload.String())}
+	packet := p.packet()
 	return packet.WriteBinary(b)
 }
 
+func (p *SDPPacket) packet() Packet {
+	packet := Packet{Header: p.Header, Payload: []byte(p.Payload.String())}
+	if packet.Compressed {
+		packet.Payload = deflate(packet.Payload)
+	}
+	return packet
+}
+
 func (a *AuthData) writeBinary(b []byte) error {
 	b[0] = a.Version<<5 + booluint8(a.Padding)<<4 + (a.AuthMethod & 0xff)
 	copy(b[1:], a.Data)
@@ -231,17 +290,22 @@ func (a *AuthData) writeBinary(b []byte) error {
 }
 
 func (p *Packet) Length() int {
-	p.recomputeLen()
+	// Errors are reported by WriteBinary
+	_ = p.recomputeLen()
 	return p.len + len(p.Payload)
 }
 func (p *SDPPacket) Length() int {
-	p.recomputeLen()
-	return p.len + len([]byte(p.Payload.String()))
+	packet := p.packet()
+	return packet.Length()
 }
 
-func (h *Header) recomputeLen() {
+func (h *Header) recomputeLen() error {
 	if h.AuthData != nil {
-		h.AuthLen = h.AuthData.reflowPadding()
+		authlen, err := h.AuthData.reflowPadding()
+		if err != nil {
+			return err
+		}
+		h.AuthLen = authlen
 	}
 	h.len = 4 + int(h.AuthLen)*4
 	h.AddressType = (h.OrigSrc.To4() == nil)
@@ -251,40 +315,107 @@ func (h *Header) recomputeLen() {
 		h.len += net.IPv6len
 	}
 
+	if h.Version == 0 && h.Encrypted {
+		h.len += 4
+	}
 	if h.Version != 0 {
 		h.len += len([]byte(h.PayloadType)) + 1
 	}
+	return nil
 }
 
-func (a *AuthData) reflowPadding() uint8 {
-	// FIXME: error when data is too large
-	authlen := uint8(len(a.Data) + 1)
+// ErrAuthDataTooLarge is returned when the authentication data does not fit in the 255 words allowed by AuthLen
+var ErrAuthDataTooLarge = errors.New("authentication data too large")
+
+// reflowPadding pads the authentication data to a multiple of 32 bits, and returns its length in 32 bits words
+func (a *AuthData) reflowPadding() (uint8, error) {
+	authlen := len(a.Data) + 1
 	if a.Padding {
-		authlen += a.PaddingLen
+		authlen += int(a.PaddingLen)
 	}
 	if authlen%4 != 0 {
-		authlen -= a.PaddingLen
-		a.PaddingLen = uint8(4 - ((len(a.Data) + 1) % 4))
-		a.Padding = true
-		return authlen/4 + 1
+		padding := (4 - (len(a.Data)+1)%4) % 4
+		a.Padding = padding != 0
+		a.PaddingLen = uint8(padding)
+		authlen = len(a.Data) + 1 + padding
+	}
+	if authlen/4 > 0xff {
+		return 0, ErrAuthDataTooLarge
 	}
-	return authlen / 4
+	return uint8(authlen / 4), nil
 }
 
 // ParseSDP converts an sap.Packet into an sap.SDPPacket by parsing the payload as SDP
 func (p *Packet) ParseSDP() (sdppacket *SDPPacket, err error) {
 	if p.PayloadType != SDPPayloadType {
-		err = errors.New("invalid payload type: " + p.PayloadType)
+		err = &ParseError{Kind: KindUnsupportedPayload, Field: "payload type", Offset: p.len, Packet: copyBytes(p.raw),
+			Err: errors.New(p.PayloadType)}
+		return
+	}
+	if p.Encrypted && !p.Decrypted {
+		err = &ParseError{Kind: KindEncrypted, Field: "payload", Offset: p.len, Packet: copyBytes(p.raw)}
 		return
 	}
 
-	desc, err := sdp.Parse(p.Payload)
+	payload, err := p.DecompressedPayload()
+	if err != nil {
+		err = &ParseError{Kind: KindPayload, Field: "compressed payload", Offset: p.len, Packet: copyBytes(p.raw), Err: err}
+		return
+	}
+	desc, err := sdp.Parse(payload)
 	if err != nil {
+		err = &ParseError{Kind: KindPayload, Field: "payload", Offset: p.len, Packet: copyBytes(p.raw), Err: err}
 		return
 	}
 	sdppacket = &SDPPacket{
-		Header:  p.Header,
-		Payload: *desc,
+		Header:   p.Header,
+		Payload:  *desc,
+		Received: p.Received,
 	}
 	return
 }
+
+// MaxDecompressedSize is the maximum size of a decompressed payload, as a protection against decompression bombs
+var MaxDecompressedSize = 1 << 16
+
+// DecompressedPayload returns the payload of the packet, inflated if the packet is compressed
+func (p *Packet) DecompressedPayload() ([]byte, error) {
+	if !p.Compressed {
+		return p.Payload, nil
+	}
+	return inflate(p.Payload)
+}
+
+// Compress compresses the payload of the packet with zlib and sets the Compressed flag
+func (p *Packet) Compress() {
+	if p.Compressed {
+		return
+	}
+	p.Payload = deflate(p.Payload)
+	p.Compressed = true
+}
+
+func inflate(b []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	payload, err := ioutil.ReadAll(io.LimitReader(r, int64(MaxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > MaxDecompressedSize {
+		return nil, errors.New("decompressed payload too large")
+	}
+	return payload, nil
+}
+
+func deflate(b []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	// Writes to a bytes.Buffer do not fail
+	_, _ = w.Write(b)
+	_ = w.Close()
+	return buf.Bytes()
+}
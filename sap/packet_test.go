@@ -317,3 +317,200 @@ func TestPadding(t *testing.T) {
 		t.Errorf("Incorrect padding length written")
 	}
 }
+
+func TestCompression(t *testing.T) {
+	sapp := SDPPacket{
+		Header: Header{
+			Version:     1,
+			Compressed:  true,
+			IDHash:      0xf830,
+			OrigSrc:     net.IP{192, 0, 2, 1},
+			PayloadType: SDPPayloadType,
+		},
+		Payload: sdp.Session{Name: "compressed"},
+	}
+	serialized := make([]byte, sapp.Length())
+	n, err := sapp.WriteBinary(serialized)
+	if err != nil {
+		t.Fatalf("Error writing out compressed packet: %v", err)
+	}
+	header, err := ParseHeader(serialized[:n])
+	if err != nil {
+		t.Fatalf("Error parsing compressed packet: %v", err)
+	}
+	if !header.Compressed || header.Encrypted {
+		t.Errorf("Incorrect flags for compressed packet: %+v", header)
+	}
+	packet := Packet{Header: header, Payload: serialized[header.len:n]}
+	decoded, err := packet.ParseSDP()
+	if err != nil {
+		t.Fatalf("Could not decode compressed SDP payload: %v", err)
+	}
+	if decoded.Payload.Name != "compressed" {
+		t.Errorf("Invalid decompressed payload: %s", decoded.Payload.String())
+	}
+
+	bomb := Packet{Payload: make([]byte, MaxDecompressedSize+1)}
+	bomb.Compress()
+	if _, err := bomb.DecompressedPayload(); err == nil {
+		t.Errorf("Oversized decompressed payload accepted")
+	}
+}
+
+func TestCompressedVector(t *testing.T) {
+	// Compressed SAPv1 announcement: the C bit is the lowest bit of the flags, E is the next one
+	b, _ := hex.DecodeString("2100f830c00002016170706c69636174696f6e2f7364700078da2bb335e0e5cab7d5553031523054f0f453f0" +
+		"0c305130b434d233d033d233e4e52ab64dcecf2d284a2d2e4e4de1e54ab685aa303236d6333205a932d43734e3e52ab13550001a946b5b" +
+		"9699929aaf606a6060a0101412a0ef1816a0606ccccb0500e3a217ff")
+	header, err := ParseHeader(b)
+	if err != nil {
+		t.Fatalf("Could not parse compressed packet: %v", err)
+	}
+	if !header.Compressed || header.Encrypted {
+		t.Errorf("Incorrect flags for compressed packet: %+v", header)
+	}
+	packet := Packet{Header: header, Payload: b[header.len:]}
+	decoded, err := packet.ParseSDP()
+	if err != nil {
+		t.Fatalf("Could not decode compressed SDP payload: %v", err)
+	}
+	if decoded.Payload.Name != "compressed" {
+		t.Errorf("Invalid decompressed payload: %s", decoded.Payload.String())
+	}
+
+	written := make([]byte, packet.Length())
+	if n, err := packet.WriteBinary(written); err != nil || written[0] != b[0] {
+		t.Errorf("Flags not written back: %x (%v)", written[:n], err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		packet int
+		kind   ErrorKind
+		offset int
+	}{
+		{6, KindPayloadType, 20},
+		{7, KindVersion, 0},
+		{8, KindLength, 1},
+		{9, KindLength, 4},
+		{10, KindLength, 4},
+		{12, KindLength, 20},
+		{14, KindAuthPadding, 23},
+		{15, KindAuthVersion, 20},
+	}
+	for _, test := range tests {
+		packet, _ := hex.DecodeString(testPackets[test.packet-1].hexStream)
+		_, err := ParseHeader(packet)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%d: Expected a ParseError, got %v", test.packet, err)
+			continue
+		}
+		if perr.Kind != test.kind || perr.Offset != test.offset || !bytes.Equal(perr.Packet, packet) {
+			t.Errorf("%d: Expected %v at byte %d, got %v", test.packet, test.kind, test.offset, perr)
+		}
+		// The packet of the error must not alias the parsed buffer, which readers reuse
+		packet[0] ^= 0xff
+		if perr.Packet[0] == packet[0] {
+			t.Errorf("%d: Packet of the error aliases the parsed buffer", test.packet)
+		}
+	}
+
+	packet := Packet{Header: testPackets[10].expected, Payload: testPackets[10].rawPayload}
+	if _, err := packet.ParseSDP(); KindOf(err) != KindUnsupportedPayload {
+		t.Errorf("Unsupported payload type not reported: %v", err)
+	}
+}
+
+func TestSAPv0(t *testing.T) {
+	tests := []struct {
+		hexStream string
+		valid     bool
+		timeout   uint32
+		payload   string
+	}{
+		{ // 1: Plain SAPv0 announcement, implicit SDP
+			"0000f830c0000201763d30", true, 0, "v=0"},
+		{ // 2: SAPv0 announcement with a SAPv1 payload type
+			"0000f830c00002016170706c69636174696f6e2f73647000763d30", true, 0, "v=0"},
+		{ // 3: Encrypted SAPv0 announcement with a timeout
+			"0200f830c0000201e1d0e5b0ffff", true, 0xe1d0e5b0, "\xff\xff"},
+		{ // 4: Encrypted SAPv0 announcement without room for the timeout
+			"0200f830c0000201e1d0", false, 0, ""},
+	}
+	for i, test := range tests {
+		b, _ := hex.DecodeString(test.hexStream)
+		header, err := ParseHeader(b)
+		if !test.valid {
+			if KindOf(err) != KindLength {
+				t.Errorf("%d: Expected length error, got %v", i+1, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d: Could not parse SAPv0 packet: %v", i+1, err)
+			continue
+		}
+		if header.PayloadType != SDPPayloadType || header.Timeout != test.timeout {
+			t.Errorf("%d: Invalid SAPv0 header: %+v", i+1, header)
+		}
+		if payload := string(b[header.len:]); payload != test.payload {
+			t.Errorf("%d: Expected payload %q, got %q", i+1, test.payload, payload)
+		}
+
+		p := Packet{Header: header, Payload: b[header.len:]}
+		written := make([]byte, p.Length())
+		n, err := p.WriteBinary(written)
+		if err != nil {
+			t.Errorf("%d: Could not write SAPv0 packet: %v", i+1, err)
+		} else if reparsed, err := ParseHeader(written[:n]); err != nil || reparsed.Timeout != header.Timeout ||
+			reparsed.Version != 0 || string(written[reparsed.len:n]) != test.payload {
+			t.Errorf("%d: SAPv0 packet not written back: %x (%v)", i+1, written[:n], err)
+		}
+	}
+
+	header := Header{Timeout: ntpEpochOffset + 60}
+	if expires := header.TimeoutTime(); expires.Unix() != 60 {
+		t.Errorf("Invalid NTP timeout conversion: %v", expires)
+	}
+}
+
+func benchmarkPacket(b testing.TB) []byte {
+	p := Packet{
+		Header:  Header{Version: 1, IDHash: 0xf830, OrigSrc: net.IP{192, 0, 2, 1}, PayloadType: SDPPayloadType},
+		Payload: []byte(testSDP),
+	}
+	buf := make([]byte, p.Length())
+	n, err := p.WriteBinary(buf)
+	if err != nil {
+		b.Fatalf("Could not write packet: %v", err)
+	}
+	return buf[:n]
+}
+
+func BenchmarkParseHeader(b *testing.B) {
+	buf := benchmarkPacket(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseHeader(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseSDP(b *testing.B) {
+	buf := benchmarkPacket(b)
+	header, err := ParseHeader(buf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	p := Packet{Header: header, Payload: buf[header.len:]}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.ParseSDP(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"sync"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// Description is the common interface of decoded SAP payloads
+type Description interface {
+	// SessionName returns the human-readable name of the session
+	SessionName() string
+	// String returns the description in its textual form
+	String() string
+}
+
+// PayloadDecoder decodes a payload into a Description. The payload may alias a pooled read buffer, see
+// Conn.ReadPooled, so it must not be retained by the Description
+type PayloadDecoder func(payload []byte) (Description, error)
+
+var (
+	decodersLock sync.RWMutex
+	decoders     = map[string]PayloadDecoder{
+		SDPPayloadType: decodeSDP,
+	}
+)
+
+// RegisterPayloadDecoder registers the decoder for a payload MIME type, replacing any previous one
+func RegisterPayloadDecoder(mimeType string, decoder PayloadDecoder) {
+	decodersLock.Lock()
+	defer decodersLock.Unlock()
+	decoders[mimeType] = decoder
+}
+
+// SDPDescription is the Description of application/sdp payloads
+type SDPDescription struct {
+	sdp.Session
+}
+
+// SessionName implements Description
+func (d *SDPDescription) SessionName() string {
+	return d.Name
+}
+
+func decodeSDP(payload []byte) (Description, error) {
+	desc, err := sdp.Parse(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &SDPDescription{Session: *desc}, nil
+}
+
+// OpaquePayload is the Description of payloads without a registered decoder
+type OpaquePayload struct {
+	Type string
+	Data []byte
+}
+
+// SessionName implements Description, opaque payloads have no name
+func (o *OpaquePayload) SessionName() string {
+	return ""
+}
+
+func (o *OpaquePayload) String() string {
+	return string(o.Data)
+}
+
+// describe returns the textual form of a possibly nil Description
+func describe(d Description) string {
+	if d == nil {
+		return ""
+	}
+	return d.String()
+}
+
+// DecodedPacket is an SAP packet with its payload decoded by the decoder registered for its payload type
+type DecodedPacket struct {
+	Header
+	Payload Description
+	// Received is the reception metadata of the packet, when read from a Conn
+	Received ReceiveInfo
+}
+
+// Decode decodes the payload of the packet with the decoder registered for its payload type. Payloads of unknown
+// types are returned as an OpaquePayload, and encrypted payloads without key as an EncryptedPayload
+func (p *Packet) Decode() (*DecodedPacket, error) {
+	if p.Encrypted && !p.Decrypted {
+		return &DecodedPacket{Header: p.Header, Payload: &EncryptedPayload{Data: copyBytes(p.Payload)},
+			Received: p.Received}, nil
+	}
+	payload, err := p.DecompressedPayload()
+	if err != nil {
+		return nil, &ParseError{Kind: KindPayload, Field: "compressed payload", Offset: p.len, Packet: copyBytes(p.raw),
+			Err: err}
+	}
+	decodersLock.RLock()
+	decoder, ok := decoders[p.PayloadType]
+	decodersLock.RUnlock()
+
+	decoded := &DecodedPacket{Header: p.Header, Received: p.Received}
+	if !ok {
+		decoded.Payload = &OpaquePayload{Type: p.PayloadType, Data: copyBytes(payload)}
+		return decoded, nil
+	}
+	if decoded.Payload, err = decoder(payload); err != nil {
+		return nil, &ParseError{Kind: KindPayload, Field: "payload", Offset: p.len, Packet: copyBytes(p.raw), Err: err}
+	}
+	return decoded, nil
+}
@@ -0,0 +1,89 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type testDescription string
+
+func (d testDescription) SessionName() string { return string(d) }
+func (d testDescription) String() string      { return string(d) }
+
+func TestDecode(t *testing.T) {
+	RegisterPayloadDecoder("application/x-test", func(b []byte) (Description, error) {
+		if len(b) == 0 {
+			return nil, errors.New("empty description")
+		}
+		return testDescription(b), nil
+	})
+
+	tests := []struct {
+		payloadType string
+		payload     string
+		valid       bool
+		name        string
+		opaque      bool
+	}{
+		{SDPPayloadType, testSDP, true, "test", false},
+		{SDPPayloadType, "garbage", false, "", false},
+		{"application/x-test", "named", true, "named", false},
+		{"application/x-test", "", false, "", false},
+		{"application/x-sd-private", "opaque", true, "", true},
+	}
+	for i, test := range tests {
+		p := &Packet{Header: Header{Version: 1, PayloadType: test.payloadType}, Payload: []byte(test.payload)}
+		decoded, err := p.Decode()
+		if !test.valid {
+			if KindOf(err) != KindPayload {
+				t.Errorf("%d: Expected payload error, got %v", i+1, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d: Could not decode payload: %v", i+1, err)
+			continue
+		}
+		if name := decoded.Payload.SessionName(); name != test.name {
+			t.Errorf("%d: Expected session name %q, got %q", i+1, test.name, name)
+		}
+		if opaque, ok := decoded.Payload.(*OpaquePayload); ok != test.opaque {
+			t.Errorf("%d: Unexpected opaque payload: %v", i+1, ok)
+		} else if ok && (opaque.Type != test.payloadType || string(opaque.Data) != test.payload) {
+			t.Errorf("%d: Opaque payload not passed through: %+v", i+1, opaque)
+		}
+	}
+}
+
+func TestOpaqueTracking(t *testing.T) {
+	channels := channelMap{lifetimes: make(map[SessionKey]AdvLifetime)}
+	hash := SessionKey{IDHash: 1}
+	p := &DecodedPacket{Header: Header{IDHash: 1, PayloadType: "application/x-sd-private"},
+		Payload: &OpaquePayload{Type: "application/x-sd-private", Data: []byte("v1")}}
+
+	if ev := channels.refresh(hash, p); ev.Type != SessionAdded || ev.After.PayloadType != p.PayloadType {
+		t.Errorf("Opaque session not tracked: %+v", ev)
+	}
+	if ev := channels.refresh(hash, p); ev.Type != SessionRefreshed {
+		t.Errorf("Expected refresh of opaque session, got %v", ev.Type)
+	}
+	p.Payload = &OpaquePayload{Type: "application/x-sd-private", Data: []byte("v2")}
+	if ev := channels.refresh(hash, p); ev.Type != SessionModified ||
+		!strings.Contains(ev.After.Description.String(), "v2") {
+		t.Errorf("Modified opaque session not detected: %+v", ev)
+	}
+}
@@ -0,0 +1,87 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"net"
+	"sync"
+
+	"github.com/Natolumin/multidrop/mcastutil"
+)
+
+// readBuffer is a pooled read buffer along with the packet read into it
+type readBuffer struct {
+	b      []byte
+	oob    []byte
+	packet Packet
+}
+
+var readBuffers = sync.Pool{
+	New: func() interface{} { return new(readBuffer) },
+}
+
+// ReadPooled reads a packet like ReadSize, but into a pooled buffer. The payload, originating source and
+// authentication data of the packet alias the buffer: they are only valid until Release is called, and must be copied
+// out, eg. with Clone, to be kept. Release must be called on every non-nil packet returned
+func (c *Conn) ReadPooled(bufSize int) (*Packet, error) {
+	rb := readBuffers.Get().(*readBuffer)
+	if size := readBufferSize(bufSize); cap(rb.b) < size {
+		rb.b = make([]byte, size)
+	} else {
+		rb.b = rb.b[:size]
+	}
+	if cap(rb.oob) < mcastutil.OOBSize {
+		rb.oob = make([]byte, mcastutil.OOBSize)
+	}
+	rb.packet = Packet{pool: rb}
+	p, err := c.readInto(&rb.packet, rb.b, rb.oob[:mcastutil.OOBSize])
+	if p == nil {
+		readBuffers.Put(rb)
+	}
+	return p, err
+}
+
+// Release returns the buffer of a packet read by ReadPooled to the pool. The packet and anything aliasing it must not
+// be used afterwards. It does nothing for other packets
+func (p *Packet) Release() {
+	rb := p.pool
+	if rb == nil {
+		return
+	}
+	rb.packet = Packet{}
+	readBuffers.Put(rb)
+}
+
+// Clone returns a copy of the packet which does not alias its read buffer
+func (p *Packet) Clone() *Packet {
+	c := *p
+	c.pool = nil
+	c.OrigSrc = net.IP(copyBytes(p.OrigSrc))
+	c.Payload = copyBytes(p.Payload)
+	c.raw = copyBytes(p.raw)
+	if p.AuthData != nil {
+		auth := *p.AuthData
+		auth.Data = copyBytes(auth.Data)
+		c.AuthData = &auth
+	}
+	return &c
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
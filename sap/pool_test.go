@@ -0,0 +1,128 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func loopbackPair(tb testing.TB) (*Conn, *net.UDPConn) {
+	lconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Skipf("Could not listen on loopback: %v", err)
+	}
+	sender, err := net.DialUDP("udp4", nil, lconn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		lconn.Close()
+		tb.Fatalf("Could not create sender: %v", err)
+	}
+	return (*Conn)(lconn), sender
+}
+
+func TestReadPooled(t *testing.T) {
+	conn, sender := loopbackPair(t)
+	defer (*net.UDPConn)(conn).Close()
+	defer sender.Close()
+
+	p := Packet{
+		Header:  Header{Version: 1, IDHash: 0xf830, OrigSrc: net.IP{192, 0, 2, 1}, PayloadType: SDPPayloadType},
+		Payload: []byte(testSDP),
+	}
+	b := make([]byte, p.Length())
+	n, err := p.WriteBinary(b)
+	if err != nil {
+		t.Fatalf("Could not write packet: %v", err)
+	}
+	if _, err = sender.Write(b[:n]); err != nil {
+		t.Fatalf("Could not send packet: %v", err)
+	}
+	received, err := conn.ReadPooled(0)
+	if err != nil {
+		t.Fatalf("Could not read packet: %v", err)
+	}
+	kept := received.Clone()
+	received.Release()
+	if received.Payload != nil || received.pool != nil {
+		t.Errorf("Released packet still references its buffer")
+	}
+	received.Release()
+
+	if !bytes.Equal(kept.Payload, p.Payload) || !kept.OrigSrc.Equal(p.OrigSrc) || kept.pool != nil {
+		t.Errorf("Cloned packet does not match sent packet: %+v", kept)
+	}
+	if _, err := kept.ParseSDP(); err != nil {
+		t.Errorf("Could not parse cloned packet: %v", err)
+	}
+}
+
+func TestReadPooledParseError(t *testing.T) {
+	conn, sender := loopbackPair(t)
+	defer (*net.UDPConn)(conn).Close()
+	defer sender.Close()
+
+	invalid := []byte{0xe0, 0, 0xf8, 0x30, 192, 0, 2, 1}
+	valid := benchmarkPacket(t)
+	if _, err := sender.Write(invalid); err != nil {
+		t.Fatalf("Could not send packet: %v", err)
+	}
+	received, err := conn.ReadPooled(0)
+	perr, ok := err.(*ParseError)
+	if received == nil || !ok {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	received.Release()
+
+	// The next read may reuse the buffer of the invalid packet
+	if _, err = sender.Write(valid); err != nil {
+		t.Fatalf("Could not send packet: %v", err)
+	}
+	if received, err = conn.ReadPooled(0); err != nil {
+		t.Fatalf("Could not read packet: %v", err)
+	}
+	defer received.Release()
+	if !bytes.Equal(perr.Packet, invalid) {
+		t.Errorf("Packet of the error changed by the next read: %x", perr.Packet)
+	}
+}
+
+func benchmarkRead(b *testing.B, read func(*Conn, int) (*Packet, error)) {
+	conn, sender := loopbackPair(b)
+	defer (*net.UDPConn)(conn).Close()
+	defer sender.Close()
+	buf := benchmarkPacket(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sender.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+		p, err := read(conn, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		p.Release()
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	benchmarkRead(b, (*Conn).ReadSize)
+}
+
+func BenchmarkReadPooled(b *testing.B) {
+	benchmarkRead(b, (*Conn).ReadPooled)
+}
@@ -0,0 +1,54 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"net"
+
+	"github.com/Natolumin/multidrop/mcastutil"
+)
+
+// ReceiveInfo is the reception metadata of a packet, filled from the control messages enabled by
+// mcastutil.EnableControlMessages
+type ReceiveInfo struct {
+	// Src is the L3 source address of the packet, to compare with the OrigSrc of the header
+	Src net.IP
+	// Dst is the destination address of the packet, ie. the group it was sent to
+	Dst net.IP
+	// IfIndex is the index of the interface the packet arrived on, or 0 if unknown
+	IfIndex int
+	// HopLimit is the TTL or hop limit of the packet on arrival, or -1 if unknown
+	HopLimit int
+}
+
+// Interface returns the name of the interface the packet arrived on
+func (ri ReceiveInfo) Interface() string {
+	if ri.IfIndex == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(ri.IfIndex); err == nil {
+		return ifi.Name
+	}
+	return ""
+}
+
+func parseReceiveInfo(oob []byte, src *net.UDPAddr) ReceiveInfo {
+	var ri ReceiveInfo
+	if src != nil {
+		ri.Src = src.IP
+	}
+	ri.Dst, ri.IfIndex, ri.HopLimit = mcastutil.ParseControlMessages(oob)
+	return ri
+}
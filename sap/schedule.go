@@ -0,0 +1,96 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultBandwidthLimit is the RFC2974 default bandwidth limit for all announcements in a scope, in bits/s
+	DefaultBandwidthLimit = 4000
+	// MinAnnounceInterval is the RFC2974 lower bound for the interval between two announcements of a session
+	MinAnnounceInterval = 300 * time.Second
+)
+
+// Scheduler computes announcement times following RFC2974 section 3.1, sharing the bandwidth of a scope with the
+// other announcers seen in it
+type Scheduler struct {
+	// Streams counts the sessions announced in the scope. If nil, only the local announcement is considered
+	Streams StreamsAccumulator
+	// Filter selects the sessions of Streams which are counted, in addition to the SAP group of the scope
+	Filter ChannelFilter
+	// BandwidthLimit is the bandwidth available for announcements in the scope, in bits/s
+	BandwidthLimit int
+}
+
+// NewScheduler creates a Scheduler with the RFC2974 default limits, counting live sessions of streams
+func NewScheduler(streams StreamsAccumulator) *Scheduler {
+	return &Scheduler{
+		Streams:        streams,
+		Filter:         FilterNotExpired,
+		BandwidthLimit: DefaultBandwidthLimit,
+	}
+}
+
+// Ads returns the number of announcements currently sharing the scope of the SAP group, which are the sessions
+// received on that group. A nil group counts the sessions of all scopes
+func (s *Scheduler) Ads(group net.IP) int {
+	if s.Streams == nil {
+		return 1
+	}
+	filter := s.Filter
+	if filter == nil {
+		filter = FilterNotExpired
+	}
+	ads := len(s.Streams.Snapshot(func(lf *AdvLifetime) bool {
+		return (group == nil || lf.Received.Dst.Equal(group)) && filter(lf)
+	}))
+	if ads > 0 {
+		return ads
+	}
+	return 1
+}
+
+// Interval returns the base interval between two announcements of adSize bytes on the SAP group
+func (s *Scheduler) Interval(group net.IP, adSize int) time.Duration {
+	return announceInterval(s.Ads(group), adSize, s.BandwidthLimit)
+}
+
+// Next returns the time at which an announcement of adSize bytes last sent at last on the SAP group should be sent
+// again. It is randomized by ±1/3 of the interval to avoid synchronisation of announcers
+func (s *Scheduler) Next(group net.IP, last time.Time, adSize int) time.Time {
+	return s.next(s.Ads(group), last, adSize)
+}
+
+// next computes Next for a known number of announcements in the scope, counted once for the sessions of a group
+func (s *Scheduler) next(ads int, last time.Time, adSize int) time.Time {
+	interval := announceInterval(ads, adSize, s.BandwidthLimit)
+	offset := time.Duration(rand.Int63n(int64(interval*2/3))) - interval/3
+	return last.Add(interval + offset)
+}
+
+func announceInterval(ads, adSize, limit int) time.Duration {
+	if limit <= 0 {
+		limit = DefaultBandwidthLimit
+	}
+	interval := time.Duration(8*ads*adSize) * time.Second / time.Duration(limit)
+	if interval < MinAnnounceInterval {
+		return MinAnnounceInterval
+	}
+	return interval
+}
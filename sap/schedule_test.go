@@ -0,0 +1,73 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAnnounceInterval(t *testing.T) {
+	tests := []struct {
+		ads, adSize, limit int
+		interval           time.Duration
+	}{
+		{1, 500, DefaultBandwidthLimit, MinAnnounceInterval},
+		{1000, 500, DefaultBandwidthLimit, 1000 * time.Second},
+		{1000, 500, 0, 1000 * time.Second},
+		{1000, 500, 8000, 500 * time.Second},
+	}
+	for i, test := range tests {
+		if interval := announceInterval(test.ads, test.adSize, test.limit); interval != test.interval {
+			t.Errorf("%d: Expected interval %v, got %v", i+1, test.interval, interval)
+		}
+	}
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	s := &Scheduler{BandwidthLimit: DefaultBandwidthLimit}
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		next := s.Next(nil, now, 500).Sub(now)
+		if next < MinAnnounceInterval*2/3 || next > MinAnnounceInterval*4/3 {
+			t.Fatalf("Next announcement out of the jitter range: %v", next)
+		}
+	}
+}
+
+func TestSchedulerAds(t *testing.T) {
+	local, global := net.IP{239, 255, 255, 255}, net.IP{224, 2, 127, 254}
+	channels := channelMap{lifetimes: map[SessionKey]AdvLifetime{
+		{IDHash: 1}: {Received: ReceiveInfo{Dst: local}},
+		{IDHash: 2}: {Received: ReceiveInfo{Dst: local}},
+		{IDHash: 3}: {Received: ReceiveInfo{Dst: global}},
+	}}
+	s := &Scheduler{Streams: &channels, Filter: func(*AdvLifetime) bool { return true }}
+	tests := []struct {
+		group net.IP
+		ads   int
+	}{
+		{local, 2},
+		{global, 1},
+		{net.IP{239, 195, 255, 255}, 1},
+		{nil, 3},
+	}
+	for _, test := range tests {
+		if ads := s.Ads(test.group); ads != test.ads {
+			t.Errorf("%v: Expected %d announcements, got %d", test.group, test.ads, ads)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// Scope is a multicast scope, with the SAP group on which its sessions are announced
+type Scope struct {
+	Name  string
+	Group net.IP
+}
+
+// AdminScope is an RFC2365 administratively scoped IPv4 range. Its SAP group is the highest address of the range
+type AdminScope struct {
+	Scope
+	Range *net.IPNet
+}
+
+func adminScope(name, cidr string) AdminScope {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	group := make(net.IP, net.IPv4len)
+	for i := range group {
+		group[i] = ipnet.IP[i] | ^ipnet.Mask[i]
+	}
+	return AdminScope{Scope: Scope{Name: name, Group: group.To16()}, Range: ipnet}
+}
+
+var (
+	// IPv4GlobalScope is the scope of IPv4 multicast addresses outside of the administratively scoped range
+	// 239.0.0.0/8
+	IPv4GlobalScope = Scope{Name: "ipv4-global", Group: GroupAddr4}
+	// IPv4UnknownAdminScope is the scope of the addresses of 239.0.0.0/8 outside of IPv4AdminScopes. Its extent is
+	// unknown, so it has no SAP group
+	IPv4UnknownAdminScope = Scope{Name: "ipv4-admin"}
+	// IPv4AdminScopes are the administratively scoped IPv4 ranges with a well-known extent. Other scopes of
+	// 239.0.0.0/8 may be added, the first matching range is used
+	IPv4AdminScopes = []AdminScope{
+		adminScope("ipv4-local", "239.255.0.0/16"),
+		adminScope("ipv4-organization-local", "239.192.0.0/14"),
+	}
+)
+
+var v6ScopeNames = map[uint8]string{
+	0x1: "interface-local",
+	0x2: "link-local",
+	0x3: "realm-local",
+	0x4: "admin-local",
+	0x5: "site-local",
+	0x8: "organization-local",
+	0xe: "global",
+}
+
+func v6Scope(zone uint8) Scope {
+	name, ok := v6ScopeNames[zone]
+	if !ok {
+		name = fmt.Sprintf("scope-%x", zone)
+	}
+	return Scope{Name: name, Group: V6GroupByZone(zone)}
+}
+
+// ScopeOf returns the scope of a multicast address
+func ScopeOf(addr net.IP) (Scope, error) {
+	if addr == nil || !addr.IsMulticast() {
+		return Scope{}, fmt.Errorf("invalid multicast address %v", addr)
+	}
+	if ip4 := addr.To4(); ip4 != nil {
+		for _, s := range IPv4AdminScopes {
+			if s.Range.Contains(ip4) {
+				return s.Scope, nil
+			}
+		}
+		// RFC2365 reserves all of 239.0.0.0/8 for administratively scoped addresses
+		if ip4[0] == 239 {
+			return IPv4UnknownAdminScope, nil
+		}
+		return IPv4GlobalScope, nil
+	}
+	return v6Scope(addr[1] & 0x0f), nil
+}
+
+// ScopeOfGroup returns the scope announced on a SAP group, or false if the address is not a SAP group
+func ScopeOfGroup(group net.IP) (Scope, bool) {
+	if group.Equal(GroupAddr4) {
+		return IPv4GlobalScope, true
+	}
+	for _, s := range IPv4AdminScopes {
+		if group.Equal(s.Group) {
+			return s.Scope, true
+		}
+	}
+	if group.To4() == nil && len(group) == net.IPv6len {
+		if s := v6Scope(group[1] & 0x0f); group.Equal(s.Group) {
+			return s, true
+		}
+	}
+	return Scope{}, false
+}
+
+// connectionAddress returns the connection address of a session, at the session level or of its first media
+func connectionAddress(s *sdp.Session) (net.IP, error) {
+	conn := s.Connection
+	if conn == nil {
+		for _, m := range s.Media {
+			if len(m.Connection) > 0 {
+				conn = m.Connection[0]
+				break
+			}
+		}
+	}
+	if conn == nil {
+		return nil, errors.New("session has no connection address")
+	}
+	ip := net.ParseIP(conn.Address)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid connection address %q", conn.Address)
+	}
+	return ip, nil
+}
+
+// trackScope records the scope of the SAP group an announcement was received on, and checks that the connection
+// address of the session belongs to it
+func (lf *AdvLifetime) trackScope(dst net.IP) {
+	lf.Scope, lf.ConnectionScope, lf.MisScoped = "", "", false
+	if dst == nil {
+		return
+	}
+	if s, ok := ScopeOfGroup(dst); ok {
+		lf.Scope = s.Name
+	}
+	if addr, err := connectionAddress(&lf.Session); err == nil {
+		if s, err := ScopeOf(addr); err == nil {
+			lf.ConnectionScope = s.Name
+		}
+	}
+	if lf.ConnectionScope == IPv4UnknownAdminScope.Name {
+		// The extent of the scope is unknown, but it must not be announced outside of the administrative scopes
+		lf.MisScoped = lf.Scope != "" && !isIPv4AdminScope(lf.Scope)
+		return
+	}
+	lf.MisScoped = lf.Scope != "" && lf.ConnectionScope != "" && lf.Scope != lf.ConnectionScope
+}
+
+// isIPv4AdminScope reports whether a scope name is the name of one of IPv4AdminScopes
+func isIPv4AdminScope(name string) bool {
+	for _, s := range IPv4AdminScopes {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+func TestScopeOfGroup(t *testing.T) {
+	tests := []struct {
+		group string
+		scope string
+	}{
+		{"224.2.127.254", "ipv4-global"},
+		{"239.255.255.255", "ipv4-local"},
+		{"239.195.255.255", "ipv4-organization-local"},
+		{"ff05::2:7ffe", "site-local"},
+		{"ff0e::2:7ffe", "global"},
+		{"239.255.0.1", ""},
+		{"ff05::1", ""},
+	}
+	for i, test := range tests {
+		scope, ok := ScopeOfGroup(net.ParseIP(test.group))
+		if ok != (test.scope != "") || scope.Name != test.scope {
+			t.Errorf("%d: Expected scope %q for %s, got %q", i+1, test.scope, test.group, scope.Name)
+		}
+	}
+}
+
+func TestScopeOf(t *testing.T) {
+	tests := []struct {
+		address string
+		scope   string
+	}{
+		{"224.2.1.1", "ipv4-global"},
+		{"233.252.0.1", "ipv4-global"},
+		{"239.255.0.1", "ipv4-local"},
+		{"239.193.0.1", "ipv4-organization-local"},
+		{"239.1.2.3", "ipv4-admin"},
+		{"239.196.0.1", "ipv4-admin"},
+		{"ff15::1234", "site-local"},
+	}
+	for i, test := range tests {
+		scope, err := ScopeOf(net.ParseIP(test.address))
+		if err != nil || scope.Name != test.scope {
+			t.Errorf("%d: Expected scope %q for %s, got %q (%v)", i+1, test.scope, test.address, scope.Name, err)
+		}
+	}
+}
+
+func TestMisScoped(t *testing.T) {
+	tests := []struct {
+		dst       string
+		address   string
+		misScoped bool
+	}{
+		{"224.2.127.254", "233.252.0.1", false},
+		{"239.255.255.255", "239.255.0.1", false},
+		{"224.2.127.254", "239.255.0.1", true},
+		{"239.255.255.255", "239.193.0.1", true},
+		{"ff0e::2:7ffe", "ff0e::1234", false},
+		{"ff0e::2:7ffe", "ff15::1234", true},
+		{"239.255.255.255", "192.0.2.1", false},
+		{"239.255.255.255", "239.1.2.3", false},
+		{"239.195.255.255", "239.1.2.3", false},
+		{"224.2.127.254", "239.1.2.3", true},
+	}
+	for i, test := range tests {
+		lf := AdvLifetime{Session: sdp.Session{Connection: &sdp.Connection{Address: test.address}}}
+		lf.trackScope(net.ParseIP(test.dst))
+		if lf.MisScoped != test.misScoped {
+			t.Errorf("%d: Expected mis-scoped %v for %s announced on %s, got %v (%s, %s)", i+1, test.misScoped,
+				test.address, test.dst, lf.MisScoped, lf.Scope, lf.ConnectionScope)
+		}
+	}
+}
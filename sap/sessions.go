@@ -0,0 +1,70 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Sessions is a snapshot of the sessions of an accumulator
+type Sessions []AdvLifetime
+
+// Sort sorts the sessions with the given ordering
+func (s Sessions) Sort(less func(a, b *AdvLifetime) bool) {
+	sort.Sort(sessionSorter{s, less})
+}
+
+// Find returns the session with the given key
+func (s Sessions) Find(key SessionKey) (AdvLifetime, bool) {
+	for _, lf := range s {
+		if lf.Key == key {
+			return lf, true
+		}
+	}
+	return AdvLifetime{}, false
+}
+
+// ByName orders sessions by name, then by key
+func ByName(a, b *AdvLifetime) bool {
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return byKey(a, b)
+}
+
+// ByLastAnnounce orders sessions from the most recently announced, then by key
+func ByLastAnnounce(a, b *AdvLifetime) bool {
+	if !a.Last.Equal(b.Last) {
+		return a.Last.After(b.Last)
+	}
+	return byKey(a, b)
+}
+
+func byKey(a, b *AdvLifetime) bool {
+	if c := bytes.Compare(a.Key.OrigSrc[:], b.Key.OrigSrc[:]); c != 0 {
+		return c < 0
+	}
+	return a.Key.IDHash < b.Key.IDHash
+}
+
+type sessionSorter struct {
+	s    Sessions
+	less func(a, b *AdvLifetime) bool
+}
+
+func (s sessionSorter) Len() int           { return len(s.s) }
+func (s sessionSorter) Swap(i, j int)      { s.s[i], s.s[j] = s.s[j], s.s[i] }
+func (s sessionSorter) Less(i, j int) bool { return s.less(&s.s[i], &s.s[j]) }
@@ -0,0 +1,127 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+
+	"go.mozilla.org/pkcs7"
+	"golang.org/x/crypto/openpgp"
+)
+
+// Signer computes detached signatures for SAP packets
+type Signer interface {
+	// Method returns the authentication method of the signatures, AuthMethodPGP or AuthMethodCMS
+	Method() uint8
+	// Sign returns a signature of data
+	Sign(data []byte) ([]byte, error)
+}
+
+// PGPSigner signs packets with an OpenPGP key
+type PGPSigner struct {
+	Entity *openpgp.Entity
+}
+
+// Method implements Signer
+func (s PGPSigner) Method() uint8 {
+	return AuthMethodPGP
+}
+
+// Sign implements Signer
+func (s PGPSigner) Sign(data []byte) ([]byte, error) {
+	var sig bytes.Buffer
+	if err := openpgp.DetachSign(&sig, s.Entity, bytes.NewReader(data), nil); err != nil {
+		return nil, err
+	}
+	return sig.Bytes(), nil
+}
+
+// CMSSigner signs packets with an X.509 certificate and its private key. As the authentication data is limited to
+// 1020 bytes, small keys such as ECDSA P-256 should be used
+type CMSSigner struct {
+	Certificate *x509.Certificate
+	Key         crypto.PrivateKey
+}
+
+// Method implements Signer
+func (s CMSSigner) Method() uint8 {
+	return AuthMethodCMS
+}
+
+// Sign implements Signer
+func (s CMSSigner) Sign(data []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	// Signed attributes would not fit in the authentication data
+	if err = sd.SignWithoutAttr(s.Certificate, s.Key, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	sd.Detach()
+	return sd.Finish()
+}
+
+// maxSignRounds bounds the number of signatures computed until the authentication length is stable
+const maxSignRounds = 4
+
+// Sign computes the authentication data of the packet with signer
+func (p *Packet) Sign(signer Signer) error {
+	p.raw = nil
+	p.AuthData = &AuthData{Version: 1, AuthMethod: signer.Method()}
+	// The signature covers AuthLen, which depends on the signature length: sign until it is stable
+	for i := 0; i < maxSignRounds; i++ {
+		if err := p.recomputeLen(); err != nil {
+			return err
+		}
+		authLen := p.AuthLen
+		data, err := p.SignedData()
+		if err != nil {
+			return err
+		}
+		if p.AuthData.Data, err = signer.Sign(data); err != nil {
+			return err
+		}
+		p.AuthData.Padding, p.AuthData.PaddingLen = false, 0
+		if err = p.recomputeLen(); err != nil {
+			return err
+		}
+		if p.AuthLen == authLen {
+			return nil
+		}
+		// Signatures of variable length, eg. ECDSA ones, are padded to the length they were computed with, unless the
+		// padding would not fit in its length byte
+		if padding := int(authLen)*4 - 1 - len(p.AuthData.Data); p.AuthLen < authLen && padding <= 0xff {
+			p.AuthData.Padding = true
+			p.AuthData.PaddingLen = uint8(padding)
+			return p.recomputeLen()
+		}
+	}
+	return errors.New("signature length does not converge")
+}
+
+// Sign computes the authentication data of the packet with signer
+func (p *SDPPacket) Sign(signer Signer) error {
+	packet := p.packet()
+	if err := packet.Sign(signer); err != nil {
+		return err
+	}
+	p.Header = packet.Header
+	return nil
+}
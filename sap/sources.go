@@ -0,0 +1,99 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// SourceAnomaly is a set of inconsistencies between the announced and actual sources of a session
+type SourceAnomaly uint8
+
+const (
+	// AnomalyOrigSrcMismatch is set when the L3 source of the latest announcement differs from its OrigSrc, eg.
+	// because of NAT or of a relay
+	AnomalyOrigSrcMismatch SourceAnomaly = 1 << iota
+	// AnomalyMultipleSources is set when several L3 sources currently announce the session, eg. because of a loop
+	// or of a rogue announcer
+	AnomalyMultipleSources
+	// AnomalySourceChanged is set once the L3 source of the session has changed
+	AnomalySourceChanged
+)
+
+var anomalyNames = []string{"origsrc-mismatch", "multiple-sources", "source-changed"}
+
+func (a SourceAnomaly) String() string {
+	var names []string
+	for i, name := range anomalyNames {
+		if a&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// SourceSeen is an L3 source of announcements for a session
+type SourceSeen struct {
+	Src   net.IP
+	Last  time.Time
+	Count int
+}
+
+const (
+	// maxSources bounds the number of sources remembered for a session
+	maxSources = 8
+	// minSourceWindow is the minimum time during which another source is considered concurrent
+	minSourceWindow = time.Minute
+)
+
+// trackSource records the L3 source of an announcement and updates the source anomalies of the session
+func (lf *AdvLifetime) trackSource(src, origSrc net.IP, now time.Time) {
+	if src == nil {
+		return
+	}
+	if !src.Equal(origSrc) {
+		lf.Anomalies |= AnomalyOrigSrcMismatch
+	} else {
+		lf.Anomalies &^= AnomalyOrigSrcMismatch
+	}
+	if n := len(lf.Sources); n > 0 && !lf.Sources[n-1].Src.Equal(src) {
+		lf.Anomalies |= AnomalySourceChanged
+	}
+
+	// Sources are copied as AdvLifetime values are shared with consumers. The latest source is kept last
+	sources := make([]SourceSeen, 0, len(lf.Sources)+1)
+	seen := SourceSeen{Src: src, Last: now, Count: 1}
+	for _, s := range lf.Sources {
+		if s.Src.Equal(src) {
+			seen.Count += s.Count
+		} else {
+			sources = append(sources, s)
+		}
+	}
+	if len(sources) >= maxSources {
+		sources = sources[len(sources)-maxSources+1:]
+	}
+	lf.Sources = append(sources, seen)
+
+	window := activityWindow(lf)
+	lf.Anomalies &^= AnomalyMultipleSources
+	for _, s := range lf.Sources[:len(lf.Sources)-1] {
+		if now.Sub(s.Last) < window {
+			lf.Anomalies |= AnomalyMultipleSources
+		}
+	}
+}
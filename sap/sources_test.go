@@ -0,0 +1,53 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTrackSource(t *testing.T) {
+	origSrc := net.IP{192, 0, 2, 1}
+	nat := net.IP{198, 51, 100, 1}
+	now := time.Now()
+	tests := []struct {
+		src       net.IP
+		delay     time.Duration
+		anomalies SourceAnomaly
+	}{
+		{origSrc, 0, 0},
+		{origSrc, time.Second, 0},
+		{nat, time.Second, AnomalyOrigSrcMismatch | AnomalyMultipleSources | AnomalySourceChanged},
+		{origSrc, time.Second, AnomalyMultipleSources | AnomalySourceChanged},
+		{origSrc, time.Hour, AnomalySourceChanged},
+	}
+	var lf AdvLifetime
+	for i, test := range tests {
+		now = now.Add(test.delay)
+		lf.Interval = time.Second
+		lf.trackSource(test.src, origSrc, now)
+		if lf.Anomalies != test.anomalies {
+			t.Errorf("%d: Expected anomalies %v, got %v", i+1, test.anomalies, lf.Anomalies)
+		}
+	}
+	if len(lf.Sources) != 2 || !lf.Sources[1].Src.Equal(origSrc) || lf.Sources[1].Count != 4 {
+		t.Errorf("Invalid sources: %+v", lf.Sources)
+	}
+	if !FilterSourceAnomalies(AnomalySourceChanged)(&lf) || FilterSourceAnomalies(AnomalyOrigSrcMismatch)(&lf) {
+		t.Errorf("Invalid anomaly filter")
+	}
+}
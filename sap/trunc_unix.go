@@ -0,0 +1,29 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package sap
+
+import "syscall"
+
+// msgTrunc is the recvmsg flag set on truncated datagrams
+const msgTrunc = syscall.MSG_TRUNC
+
+// truncatedRead reports whether a read failed because the datagram was larger than the buffer, which is reported by
+// msgTrunc instead on these platforms
+func truncatedRead(err error) bool {
+	return false
+}
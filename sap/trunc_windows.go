@@ -0,0 +1,38 @@
+//   Copyright 2017 Anatole Denis
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sap
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// msgTrunc is never reported on windows, where truncated reads fail with an error
+const msgTrunc = 0
+
+// wsaeMsgSize is the error of reads of datagrams larger than the buffer
+const wsaeMsgSize = syscall.Errno(10040)
+
+// truncatedRead reports whether a read failed because the datagram was larger than the buffer
+func truncatedRead(err error) bool {
+	if operr, ok := err.(*net.OpError); ok {
+		err = operr.Err
+	}
+	if syserr, ok := err.(*os.SyscallError); ok {
+		err = syserr.Err
+	}
+	return err == wsaeMsgSize
+}